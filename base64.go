@@ -0,0 +1,13 @@
+package splitter
+
+// 使用 base64 编码 ChunkData, 开启压缩时编码压缩后的数据. 编码后的数据是一个新的缓冲区, Values 仍然引用编码前的数据
+func (s *splitter) encodeChunk(args *FlushChunkArgs) {
+	if s.base64Encoding == nil || s.omitChunkData {
+		return
+	}
+
+	data := make([]byte, s.base64Encoding.EncodedLen(len(args.ChunkData)))
+	s.base64Encoding.Encode(data, args.ChunkData)
+	args.RawSize = len(args.ChunkData)
+	args.ChunkData = data
+}
@@ -0,0 +1,159 @@
+package splitter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrReadStalled = errors.New("read stalled")
+
+// 到达 idleDeadline 时返回, 用于通知 RunSplit 检查是否需要 flush 空闲的 chunk
+var errIdleDeadline = errors.New("idle deadline exceeded")
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// 可取消的读取器, 在后台 goroutine 中调用 rd.Read, 使阻塞中的读取可以被 ctx 中断.
+// 如果设置了 readTimeout, 单次读取等待超过这个时间会返回 ErrReadStalled.
+// 如果设置了 idleDeadline, 到达这个时间后读取会返回 errIdleDeadline, 未完成的读取会在下次调用 Read 时继续等待.
+// 如果设置了 follow, rd 返回 io.EOF 时不会结束, 而是每隔 followPollInterval 重新读取.
+// 注意: 被中断的那次 rd.Read 会在后台一直等待直到 rd 返回, 无法真正终止底层读取.
+type cancelReader struct {
+	ctx         context.Context
+	rd          io.Reader
+	readTimeout time.Duration
+	timer       *time.Timer // 用于 readTimeout, 每次读取时重置
+
+	idleDeadline time.Time   // 为零值时表示不设置
+	idleTimer    *time.Timer // 用于 idleDeadline
+
+	follow             bool          // 跟随模式
+	followPollInterval time.Duration // 跟随模式下遇到 EOF 后的重试间隔
+
+	buf     []byte // 后台读取使用的缓冲区
+	pending []byte // 已读取但还未返回的数据
+	err     error  // 在返回 pending 数据后需要返回的错误
+
+	reading bool // 是否有正在进行的后台读取
+	result  chan readResult
+}
+
+func newCancelReader(ctx context.Context, rd io.Reader, readTimeout time.Duration) *cancelReader {
+	return &cancelReader{
+		ctx:         ctx,
+		rd:          rd,
+		readTimeout: readTimeout,
+		result:      make(chan readResult, 1),
+	}
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		err := c.err
+		c.err = nil
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var idle <-chan time.Time
+	if !c.idleDeadline.IsZero() {
+		d := time.Until(c.idleDeadline)
+		if d <= 0 {
+			return 0, errIdleDeadline
+		}
+		if c.idleTimer == nil {
+			c.idleTimer = time.NewTimer(d)
+		} else {
+			c.idleTimer.Reset(d)
+		}
+		defer c.idleTimer.Stop()
+		idle = c.idleTimer.C
+	}
+
+	var stalled <-chan time.Time
+	if c.readTimeout > 0 {
+		if c.timer == nil {
+			c.timer = time.NewTimer(c.readTimeout)
+		} else {
+			c.timer.Reset(c.readTimeout)
+		}
+		defer c.timer.Stop()
+		stalled = c.timer.C
+	}
+
+	for {
+		// 没有正在进行的读取时才开始新的读取, 否则等待上次被中断的读取结果
+		if !c.reading {
+			if cap(c.buf) < len(p) {
+				c.buf = make([]byte, len(p))
+			}
+			buf := c.buf[:len(p)]
+			c.reading = true
+			go func() {
+				n, err := c.rd.Read(buf)
+				c.result <- readResult{n: n, err: err}
+			}()
+		}
+
+		select {
+		case r := <-c.result:
+			c.reading = false
+			// 跟随模式下 EOF 表示暂时没有数据, 等待一段时间后重新读取
+			if c.follow && r.err == io.EOF {
+				r.err = nil
+				if r.n == 0 {
+					if err := c.waitPoll(stalled, idle); err != nil {
+						return 0, err
+					}
+					continue
+				}
+			}
+
+			n := copy(p, c.buf[:r.n])
+			c.pending = c.buf[n:r.n]
+			if r.err != nil && len(c.pending) > 0 {
+				c.err = r.err
+				return n, nil
+			}
+			return n, r.err
+		case <-c.ctx.Done():
+			return 0, c.ctx.Err()
+		case <-stalled:
+			return 0, ErrReadStalled
+		case <-idle:
+			return 0, errIdleDeadline
+		}
+	}
+}
+
+// 跟随模式下等待下一次轮询
+func (c *cancelReader) waitPoll(stalled, idle <-chan time.Time) error {
+	t := time.NewTimer(c.followPollInterval)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-stalled:
+		return ErrReadStalled
+	case <-idle:
+		return errIdleDeadline
+	}
+}
+
+// 设置空闲截止时间, 传入零值表示取消
+func (c *cancelReader) SetIdleDeadline(t time.Time) {
+	c.idleDeadline = t
+}
@@ -0,0 +1,62 @@
+package splitter
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStopInterruptsBlockedRead(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		pr, pw := io.Pipe()
+		var chunks []string
+		var stopped bool
+		s := NewSplitter(Conf{
+			Delim: []byte("\n"),
+			FlushChunkHandler: func(args *FlushChunkArgs) error {
+				chunks = append(chunks, string(args.ChunkData))
+				stopped = args.IsStopped
+				return nil
+			},
+		})
+		runErr := make(chan error, 1)
+		go func() { runErr <- s.RunSplit(pr) }()
+
+		// 写入部分数据后不再写入也不关闭, RunSplit 会阻塞在 Read
+		if _, err := pw.Write([]byte("a\nb\n")); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for s.ScanByteNum() < 4 {
+			if time.Now().After(deadline) {
+				t.Fatal("data not scanned")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		start := time.Now()
+		if flush {
+			s.StopAndFlush()
+		} else {
+			s.Stop()
+		}
+		select {
+		case err := <-runErr:
+			if !errors.Is(err, ErrSplitterIsStopped) {
+				t.Fatalf("flush %v: got %v, want ErrSplitterIsStopped", flush, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("flush %v: RunSplit not returned %v after Stop", flush, time.Since(start))
+		}
+		if flush {
+			if len(chunks) != 1 || chunks[0] != "a\nb" || !stopped {
+				t.Fatalf("StopAndFlush: got chunks %q, IsStopped %v", chunks, stopped)
+			}
+		} else if len(chunks) != 0 {
+			t.Fatalf("Stop: got chunks %q, want none", chunks)
+		}
+		pw.Close() // 结束后台阻塞的 Read
+	}
+}
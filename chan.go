@@ -0,0 +1,70 @@
+package splitter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+var ErrChunkChanHandler = errors.New("chunk chan splitter can not set flush chunk handler")
+
+// 通过 chan 接收 chunk 的分隔器
+type ChunkChanSplitter interface {
+	Splitter
+	// 返回当前运行使用的 chunk chan, chunk 数据归接收方所有. 运行结束后会关闭, 之后可以通过 LastError 获取运行结果.
+	// Reset 后会创建新的 chan, 需要重新调用 Chunks 获取
+	Chunks() <-chan *FlushChunkArgs
+}
+
+// 创建一个将 chunk 发送到 chan 的分隔器, bufSize 为 chan 的缓冲区大小, 接收不及时时会阻塞读取.
+// 和 FlushChunkHandler, FlushChunkCtxHandler, FlushChunkStreamHandler 互斥, 设置了它们时返回 ErrChunkChanHandler
+func NewChunkChanSplitter(conf Conf, bufSize int) (ChunkChanSplitter, error) {
+	if conf.FlushChunkHandler != nil || conf.FlushChunkCtxHandler != nil || conf.FlushChunkStreamHandler != nil {
+		return nil, ErrChunkChanHandler
+	}
+	sp, err := NewSplitterE(conf)
+	if err != nil {
+		return nil, err
+	}
+	s := sp.(*splitter)
+	s.chunkChan = make(chan *FlushChunkArgs, max(bufSize, 0))
+	return s, nil
+}
+
+func (s *splitter) Chunks() <-chan *FlushChunkArgs {
+	return s.chunkChan
+}
+
+// 以 chan 的方式运行分隔
+func (s *splitter) RunSplitChan(rd io.Reader, bufSize int) (<-chan *FlushChunkArgs, <-chan error) {
+	errCh := make(chan error, 1)
+
+	// 防止重复调用
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		chunkCh := make(chan *FlushChunkArgs)
+		close(chunkCh)
+		errCh <- ErrSplitterIsStarted
+		close(errCh)
+		return chunkCh, errCh
+	}
+
+	// 通过 NewChunkChanSplitter 创建时使用自己的 chan, 运行结束时会被关闭
+	if s.chunkChan != nil {
+		go func() {
+			errCh <- s.run(context.Background(), rd)
+			close(errCh)
+		}()
+		return s.chunkChan, errCh
+	}
+
+	chunkCh := make(chan *FlushChunkArgs, max(bufSize, 0))
+	s.chunkCh = chunkCh
+	go func() {
+		err := s.run(context.Background(), rd)
+		close(chunkCh)
+		errCh <- err
+		close(errCh)
+	}()
+	return chunkCh, errCh
+}
@@ -0,0 +1,18 @@
+package splitter
+
+import (
+	"strconv"
+)
+
+// chunk ID 生成函数, 在 chunk flush 前调用一次, 返回值会写入 FlushChunkArgs.ChunkID
+type ChunkIDFunc func(chunkSn int, args *FlushChunkArgs) string
+
+// 默认的 chunk ID, 即 ChunkSn 的十进制字符串
+func defaultChunkID(chunkSn int, _ *FlushChunkArgs) string {
+	return strconv.Itoa(chunkSn)
+}
+
+// 为 chunk 生成 ID, 每个 chunk 只生成一次, 重试 flush 时 ID 保持不变
+func (s *splitter) stampChunkID(args *FlushChunkArgs) {
+	args.ChunkID = s.chunkIDFunc(args.ChunkSn, args)
+}
@@ -0,0 +1,69 @@
+package splitter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChunkIDStableAcrossRetry(t *testing.T) {
+	for _, concurrency := range []int{0, 4} {
+		var generated atomic.Int32
+		var mx sync.Mutex
+		attempts := make(map[int][]string) // ChunkSn -> 每次调用 handler 时的 ChunkID
+		s := NewSplitter(Conf{
+			Delim:                []byte("\n"),
+			ChunkSizeLimit:       1024,
+			ChunkValueCountLimit: 2,
+			FlushConcurrency:     concurrency,
+			FlushRetry:           FlushRetry{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			ChunkIDFunc: func(chunkSn int, args *FlushChunkArgs) string {
+				return fmt.Sprintf("id-%d-%d", chunkSn, generated.Add(1))
+			},
+			FlushChunkHandler: func(args *FlushChunkArgs) error {
+				mx.Lock()
+				defer mx.Unlock()
+				attempts[args.ChunkSn] = append(attempts[args.ChunkSn], args.ChunkID)
+				if len(attempts[args.ChunkSn]) == 1 {
+					return errors.New("temporary")
+				}
+				return nil
+			},
+		})
+		if err := s.RunSplit(strings.NewReader("a\nb\nc\nd\ne")); err != nil {
+			t.Fatal(err)
+		}
+		if len(attempts) != 3 || int(generated.Load()) != 3 {
+			t.Fatalf("concurrency %d: %d chunks, ChunkIDFunc called %d times", concurrency, len(attempts), generated.Load())
+		}
+		for sn, ids := range attempts {
+			if len(ids) != 2 || ids[0] != ids[1] || !strings.HasPrefix(ids[0], "id-"+strconv.Itoa(sn)+"-") {
+				t.Errorf("concurrency %d: chunk %d got ids %q", concurrency, sn, ids)
+			}
+		}
+	}
+}
+
+func TestDefaultChunkID(t *testing.T) {
+	var ids []string
+	s := NewSplitter(Conf{
+		Delim:                []byte("\n"),
+		ChunkSizeLimit:       1024,
+		ChunkValueCountLimit: 1,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			ids = append(ids, args.ChunkID)
+			return nil
+		},
+	})
+	if err := s.RunSplit(strings.NewReader("a\nb\nc")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "0,1,2" {
+		t.Fatalf("got ids %q", ids)
+	}
+}
@@ -0,0 +1,27 @@
+package splitter
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ChunkData 的缓冲区池, 开启 PoolChunkData 时使用
+var chunkDataPool = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// 从缓冲区池获取一个缓冲区并复制 src
+func getPooledChunkData(src []byte) *[]byte {
+	buf := chunkDataPool.Get().(*[]byte)
+	*buf = append((*buf)[:0], src...)
+	return buf
+}
+
+// 将 ChunkData 的缓冲区归还到缓冲区池, 之后不能再使用 ChunkData 和 Values.
+// 仅在开启 PoolChunkData 时有效, 重复调用是安全的. 不调用时缓冲区会被 GC 回收, 不会泄露
+func (a *FlushChunkArgs) Release() {
+	if a.pooledData == nil || !atomic.CompareAndSwapInt32(&a.released, 0, 1) {
+		return
+	}
+	chunkDataPool.Put(a.pooledData)
+}
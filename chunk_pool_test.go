@@ -0,0 +1,90 @@
+package splitter
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPoolChunkDataRelease(t *testing.T) {
+	input := numberedInput(500)
+	var held []*FlushChunkArgs
+	s := NewSplitter(Conf{
+		Delim:          []byte("\n"),
+		ChunkSizeLimit: 100,
+		PoolChunkData:  true,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			held = append(held, args)
+			return nil
+		},
+	})
+	if err := s.RunSplit(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	// handler 返回后仍然可以持有 ChunkData
+	var out bytes.Buffer
+	for _, args := range held {
+		out.Write(args.ChunkData)
+		out.WriteByte('\n')
+	}
+	if out.String() != input {
+		t.Fatal("pooled chunk data changed before Release")
+	}
+	// 重复 Release 是安全的, 没有 Release 的 chunk 由 GC 回收
+	for i, args := range held {
+		if i%2 == 0 {
+			args.Release()
+			args.Release()
+		}
+	}
+}
+
+func TestReleaseWithoutPool(t *testing.T) {
+	args := &FlushChunkArgs{ChunkData: []byte("a")}
+	args.Release()
+	if string(args.ChunkData) != "a" {
+		t.Fatal("Release changed chunk data without PoolChunkData")
+	}
+}
+
+// handler 将 chunk 交给后台 goroutine 异步处理
+func benchmarkAsyncChunks(b *testing.B, pool bool) {
+	input := []byte(strings.Repeat(strings.Repeat("x", 1023)+"\n", 4<<10))
+	jobs := make(chan *FlushChunkArgs, 16)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for args := range jobs {
+			_ = bytes.IndexByte(args.ChunkData, 0)
+			args.Release()
+		}
+	}()
+	s := NewSplitter(Conf{
+		Delim:          []byte("\n"),
+		ChunkSizeLimit: 64 << 10,
+		PoolChunkData:  pool,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			jobs <- args
+			return nil
+		},
+	})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for range b.N {
+		if err := s.Reset(); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.RunSplit(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	close(jobs)
+	wg.Wait()
+}
+
+func BenchmarkAsyncChunksCopy(b *testing.B) { benchmarkAsyncChunks(b, false) }
+func BenchmarkAsyncChunksPool(b *testing.B) { benchmarkAsyncChunks(b, true) }
@@ -0,0 +1,26 @@
+package splitter
+
+// 使用 gzip 压缩 ChunkData, 压缩后的数据是一个新的缓冲区, Values 仍然引用压缩前的数据.
+// 同时开启 base64 编码时压缩后的数据会直接引用 gzipBuffer, 由编码时复制
+func (s *splitter) compressChunk(args *FlushChunkArgs) error {
+	if s.gzipWriter == nil || s.omitChunkData {
+		return nil
+	}
+
+	s.gzipBuffer.Reset()
+	s.gzipWriter.Reset(&s.gzipBuffer)
+	if _, err := s.gzipWriter.Write(args.ChunkData); err != nil {
+		return err
+	}
+	if err := s.gzipWriter.Close(); err != nil {
+		return err
+	}
+
+	args.UncompressedSize = len(args.ChunkData)
+	if s.base64Encoding != nil {
+		args.ChunkData = s.gzipBuffer.Bytes()
+		return nil
+	}
+	args.ChunkData = append([]byte(nil), s.gzipBuffer.Bytes()...)
+	return nil
+}
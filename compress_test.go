@@ -0,0 +1,86 @@
+package splitter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+)
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCompressChunks(t *testing.T) {
+	input := numberedInput(500)
+	base := Conf{Delim: []byte("\n"), ChunkSizeLimit: 200, EnableChecksum: true}
+	want := mustCollectChunks(t, base, input)
+	cases := []struct {
+		name        string
+		level       int
+		base64      bool
+		concurrency int
+	}{
+		{"default level", 0, false, 0},
+		{"best speed", gzip.BestSpeed, false, 0},
+		{"best compression", gzip.BestCompression, false, 0},
+		{"huffman only", gzip.HuffmanOnly, false, 0},
+		{"base64", 0, true, 0},
+		{"concurrent flush", 0, false, 4},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := base
+			conf.CompressChunks = true
+			conf.CompressLevel = c.level
+			conf.EncodeChunkBase64 = c.base64
+			conf.FlushConcurrency = c.concurrency
+			got := mustCollectChunks(t, conf, input)
+			if len(got) != len(want) {
+				t.Fatalf("got %d chunks, want %d", len(got), len(want))
+			}
+			for sn, w := range want {
+				g := got[sn]
+				data := g.ChunkData
+				if c.base64 {
+					decoded, err := base64.StdEncoding.DecodeString(string(data))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if g.RawSize != len(decoded) {
+						t.Errorf("chunk %d: RawSize %d, want %d", sn, g.RawSize, len(decoded))
+					}
+					data = decoded
+				}
+				if plain := gunzip(t, data); !bytes.Equal(plain, w.ChunkData) {
+					t.Fatalf("chunk %d: decompressed %q, want %q", sn, plain, w.ChunkData)
+				}
+				if g.UncompressedSize != len(w.ChunkData) {
+					t.Errorf("chunk %d: UncompressedSize %d, want %d", sn, g.UncompressedSize, len(w.ChunkData))
+				}
+				// 长度限制和校验和按压缩前的数据计算
+				if g.Checksum != w.Checksum || g.ValueCount != w.ValueCount {
+					t.Errorf("chunk %d: checksum/value count differs from uncompressed run", sn)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressLevelInvalid(t *testing.T) {
+	_, err := NewSplitterE(Conf{Delim: []byte("\n"), CompressChunks: true, CompressLevel: 100})
+	if !errors.Is(err, ErrInvalidCompressLevel) {
+		t.Fatalf("got %v, want ErrInvalidCompressLevel", err)
+	}
+}
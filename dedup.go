@@ -0,0 +1,54 @@
+package splitter
+
+import (
+	"container/list"
+)
+
+// 记录已出现过的 value 用于去重, maxEntries > 0 时超过这个数量会淘汰最久未出现的 value
+type dedupSet struct {
+	maxEntries int
+	ll         *list.List               // 按最近出现的顺序排列, 最前面的是最近出现的, 仅在 maxEntries > 0 时使用
+	items      map[string]*list.Element // 不限制数量时值为 nil
+}
+
+func newDedupSet(maxEntries int) *dedupSet {
+	d := &dedupSet{
+		maxEntries: maxEntries,
+		items:      make(map[string]*list.Element),
+	}
+	if maxEntries > 0 {
+		d.ll = list.New()
+	}
+	return d
+}
+
+// 检查 value 是否已出现过, 未出现过时会记录这个 value
+func (d *dedupSet) seen(value []byte) bool {
+	if e, ok := d.items[string(value)]; ok {
+		if e != nil {
+			d.ll.MoveToFront(e)
+		}
+		return true
+	}
+
+	key := string(value)
+	if d.ll == nil {
+		d.items[key] = nil
+		return false
+	}
+	d.items[key] = d.ll.PushFront(key)
+	if d.ll.Len() > d.maxEntries {
+		oldest := d.ll.Back()
+		d.ll.Remove(oldest)
+		delete(d.items, oldest.Value.(string))
+	}
+	return false
+}
+
+// 清除记录的 value
+func (d *dedupSet) reset() {
+	clear(d.items)
+	if d.ll != nil {
+		d.ll.Init()
+	}
+}
@@ -0,0 +1,47 @@
+package splitter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDefaultHandlerWritesStdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	s := NewSplitter(Conf{Delim: []byte("\n"), ChunkSizeLimit: MinChunkSizeLimit})
+	os.Stdout = stdout
+
+	input := "aaaaaaaa\nbbbbbbbb\ncccccccc\ndd"
+	runErr := s.RunSplit(strings.NewReader(input))
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	// 每个 chunk 后写入分隔符
+	if want := input + "\n"; string(out) != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
+
+func TestDefaultHandlerWriterKeepTrailingDelim(t *testing.T) {
+	var buf bytes.Buffer
+	input := "aaaaaaaa\nbbbbbbbb\ncccccccc\n"
+	s := NewSplitter(Conf{Delim: []byte("\n"), ChunkSizeLimit: MinChunkSizeLimit, KeepTrailingDelim: true, DefaultHandlerWriter: &buf})
+	if err := s.RunSplit(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != input {
+		t.Fatalf("got %q, want %q", buf.String(), input)
+	}
+}
@@ -0,0 +1,131 @@
+package splitter
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+)
+
+// 分隔符匹配函数, 返回 buf 开头的分隔符长度, <=0 表示 buf 不以分隔符开头. 用于长度可变的分隔符, 例如一个或多个空格.
+// buf 为已读取的数据, 分隔符可能被截断, 此时 buf 是分隔符的前缀应该返回 len(buf), 会读取更多数据后重新匹配
+type DelimMatchFunc func(buf []byte) (matchLen int)
+
+// 使用 delimMatch 读取下一个 value, 对已缓冲的数据逐个位置调用 delimMatch, 在第一个匹配的位置切分
+func (v *valueReader) nextMatch() ([]byte, error) {
+	l := v.partialLen
+	v.partialLen = 0
+	if l == 0 && !v.skipping {
+		v.valueStart = v.scanByteNum
+	}
+
+	done := v.ctx.Done()
+	for {
+		// 检查是否已取消
+		if done != nil {
+			select {
+			case <-done:
+				return nil, v.ctx.Err()
+			default:
+			}
+		}
+
+		// 确保有可读的数据
+		_, err := v.reader.Peek(1)
+		if err == io.EOF {
+			v.isEOF = true
+			if v.skipping {
+				v.skipping = false
+				l = 0
+				v.valueStart = v.scanByteNum
+			}
+			return v.readBuffer[:l], nil
+		}
+		if err != nil {
+			// 保留已读取的数据, 下次调用 Next 时继续读取这个 value
+			v.partialLen = l
+			v.errLen = l
+			return nil, err
+		}
+		data, _ := v.reader.Peek(v.reader.Buffered())
+
+		n, sepLen, err := v.matchDelim(data)
+		if err != nil {
+			v.partialLen = l
+			v.errLen = l
+			return nil, err
+		}
+		if n < 0 {
+			continue // 分隔符可能延续到还没有读取的数据中, 已读取更多数据, 重新查找
+		}
+		if n > v.valueMaxScanSizeLimit-l {
+			n = v.valueMaxScanSizeLimit - l
+			sepLen = 0
+		}
+
+		// 限速, 分隔符只能一次消费完, 所以前面没有数据时分隔符可以超过爆发量
+		if limiter := v.limiter.Load(); limiter != nil {
+			total, err := v.waitLimiter(limiter, n+sepLen)
+			if err != nil {
+				return nil, err
+			}
+			if total < n+sepLen && n > 0 {
+				n = min(n, total)
+				sepLen = 0
+			}
+		}
+
+		copy(v.readBuffer[l:], data[:n])
+		if sepLen > 0 && !v.skipping {
+			v.matchedDelim = append(v.matchedDelim[:0], data[n:n+sepLen]...)
+		}
+		_, _ = v.reader.Discard(n + sepLen)
+		atomic.AddInt64(&v.scanByteNum, int64(n+sepLen))
+		l += n
+
+		if sepLen > 0 {
+			if v.skipping {
+				// 丢弃完成, 开始读取下一个 value
+				v.skipping = false
+				l = 0
+				v.valueStart = v.scanByteNum
+				continue
+			}
+			return v.readBuffer[:l], nil
+		}
+
+		// 检查长度限制, 允许扩容时先尝试扩容. 分隔符不会跨越已读取的数据, 丢弃时不需要保留末尾
+		if l == v.valueMaxScanSizeLimit {
+			if v.skipping {
+				l = 0
+				continue
+			}
+			if !v.grow() {
+				v.errLen = l
+				return v.readBuffer[:l], ErrValueReaderMaxScanSizeLimit
+			}
+		}
+	}
+}
+
+// 在 data 中查找第一个分隔符, 返回分隔符之前的长度和分隔符的长度, 没有找到时返回 len(data) 和 0.
+// 分隔符延续到 data 末尾时可能还没有匹配完整, 此时会先读取更多数据并返回 n 为 -1
+func (v *valueReader) matchDelim(data []byte) (n, sepLen int, err error) {
+	for i := range data {
+		m := v.delimMatch(data[i:])
+		if m <= 0 {
+			continue
+		}
+		m = min(m, len(data)-i)
+		if i+m == len(data) && len(data) < v.reader.Size() {
+			_, err = v.reader.Peek(len(data) + 1)
+			if err == nil {
+				return -1, 0, nil
+			}
+			if err != io.EOF && err != bufio.ErrBufferFull {
+				return 0, 0, err
+			}
+		}
+		return i, m, nil
+	}
+	return len(data), 0, nil
+}
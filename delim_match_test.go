@@ -0,0 +1,89 @@
+package splitter
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// 匹配 "\n" 或 "\r\n"
+func newlineMatch(buf []byte) int {
+	switch {
+	case buf[0] == '\n':
+		return 1
+	case buf[0] == '\r' && len(buf) == 1:
+		return 1 // 可能是被截断的 "\r\n"
+	case buf[0] == '\r' && buf[1] == '\n':
+		return 2
+	}
+	return 0
+}
+
+func TestValueDelims(t *testing.T) {
+	const input = "a\nb\r\nc\r\n\nd"
+	wantValues := []string{"a", "b", "c", "d"}
+	wantDelims := []string{"\n", "\r\n", "\r\n", ""}
+	for _, minLast := range []int{0, 100} {
+		var values, delims []string
+		var chunkNum int
+		s := NewSplitter(Conf{
+			Delim:                []byte("\n"),
+			DelimMatch:           newlineMatch,
+			IncludeValues:        true,
+			ChunkSizeLimit:       1024,
+			ChunkValueCountLimit: 3,
+			MinLastChunkSize:     minLast,
+			FlushChunkHandler: func(args *FlushChunkArgs) error {
+				chunkNum++
+				if len(args.ValueDelims) != len(args.Values) {
+					t.Fatalf("got %d delims for %d values", len(args.ValueDelims), len(args.Values))
+				}
+				for i, v := range args.Values {
+					values = append(values, string(v))
+					if args.ValueDelims[i] == nil && i != len(args.Values)-1 {
+						t.Errorf("value %q: nil delim", v)
+					}
+					delims = append(delims, string(args.ValueDelims[i]))
+				}
+				return nil
+			},
+		})
+		if err := s.RunSplit(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		if wantChunks := map[int]int{0: 2, 100: 1}[minLast]; chunkNum != wantChunks {
+			t.Errorf("MinLastChunkSize %d: got %d chunks, want %d", minLast, chunkNum, wantChunks)
+		}
+		if strings.Join(values, ",") != strings.Join(wantValues, ",") {
+			t.Errorf("MinLastChunkSize %d: values = %q, want %q", minLast, values, wantValues)
+		}
+		for i := range wantDelims {
+			if delims[i] != wantDelims[i] {
+				t.Errorf("MinLastChunkSize %d: delim %d = %q, want %q", minLast, i, delims[i], wantDelims[i])
+			}
+		}
+	}
+}
+
+func TestValueDelimsPeek(t *testing.T) {
+	vr := newValueReader(context.Background(), strings.NewReader("a\r\nb\nc"), []byte("\n"), 0, 0, 0)
+	vr.delimMatch = newlineMatch
+	want := []struct{ value, delim string }{{"a", "\r\n"}, {"b", "\n"}, {"c", ""}}
+	for _, w := range want {
+		if _, err := vr.Peek(); err != nil {
+			t.Fatal(err)
+		}
+		next, err := vr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := string(next)
+		// Peek 下一个 value 不会改变当前 value 的分隔符
+		if _, err := vr.Peek(); err != nil && w.delim != "" {
+			t.Fatal(err)
+		}
+		if value != w.value || string(vr.matchedDelim) != w.delim {
+			t.Errorf("got %q/%q, want %q/%q", value, vr.matchedDelim, w.value, w.delim)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"strings"
 
 	"github.com/zlyuancn/splitter"
@@ -12,8 +13,9 @@ func main() {
 	conf := splitter.Conf{
 		Delim:          []byte(","),
 		ChunkSizeLimit: 16,
-		FlushChunkHandler: func(args *splitter.FlushChunkArgs) {
+		FlushChunkHandler: func(args *splitter.FlushChunkArgs) error {
 			println("Chunk", args.ChunkSn, "values", args.StartValueSn, "to", args.EndValueSn, ":", string(args.ChunkData))
+			return nil
 		},
 		ValueFilter: func(v []byte) []byte {
 			if string(v) == "banana" {
@@ -21,6 +23,10 @@ func main() {
 			}
 			return v
 		},
+		OnSummary: func(summary splitter.RunSummary) {
+			bs, _ := json.Marshal(summary) // 每次运行记录一条汇总
+			println("summary", string(bs))
+		},
 	}
 
 	s := splitter.NewSplitter(conf)
@@ -28,4 +34,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+
+	stats := s.Stats()
+	println("chunks", stats.ChunkNum, "values", stats.ValueNum, "discarded", stats.DiscardedValueNum, "bytes", stats.ScanByteNum)
 }
@@ -0,0 +1,189 @@
+package splitter
+
+import (
+	"context"
+	"sync"
+)
+
+// flush 工作池, 在多个 goroutine 中并发调用 FlushChunkHandler.
+// 如果设置了 orderedFlushHandler, handler 返回后会通过重排序缓冲区按 ChunkSn 顺序在单个 goroutine 中调用它
+type flushPool struct {
+	s      *splitter
+	jobs   chan *FlushChunkArgs
+	wg     sync.WaitGroup
+	cancel context.CancelCauseFunc // 出错时用于停止运行
+
+	orderedFlushHandler FlushChunkHandler
+	completed           chan *FlushChunkArgs // handler 已返回的 chunk
+	orderedDone         chan struct{}        // 顺序处理 goroutine 退出时关闭
+
+	errOnce sync.Once
+	err     error // 第一个错误
+	failed  chan struct{}
+
+	pendingMu     sync.Mutex
+	pendingCond   *sync.Cond // 积压减少或出错时唤醒阻塞的 submit
+	pendingChunks int        // 已提交但还没有处理完成的 chunk 数
+	pendingBytes  int64      // 已提交但还没有处理完成的 chunk 数据字节数
+}
+
+func newFlushPool(s *splitter, concurrency int, orderedFlushHandler FlushChunkHandler, cancel context.CancelCauseFunc) *flushPool {
+	p := &flushPool{
+		s:      s,
+		jobs:   make(chan *FlushChunkArgs),
+		cancel: cancel,
+		failed: make(chan struct{}),
+
+		orderedFlushHandler: orderedFlushHandler,
+	}
+	p.pendingCond = sync.NewCond(&p.pendingMu)
+	if orderedFlushHandler != nil {
+		p.completed = make(chan *FlushChunkArgs, concurrency)
+		p.orderedDone = make(chan struct{})
+		go p.orderedWorker(s.chunkSn)
+	}
+
+	p.wg.Add(concurrency)
+	for range concurrency {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *flushPool) worker() {
+	defer p.wg.Done()
+	for args := range p.jobs {
+		// 出错后不再调用 handler, 但是继续消费保证提交不会阻塞
+		if !p.isFailed() {
+			if err := p.s.flushWithRetry(args); err != nil {
+				p.fail(err)
+			}
+		}
+		if p.completed != nil {
+			p.completed <- args
+		} else {
+			p.release(args)
+		}
+	}
+}
+
+// 按 ChunkSn 顺序调用 orderedFlushHandler, nextSn 为第一个 chunk 的 sn
+func (p *flushPool) orderedWorker(nextSn int) {
+	defer close(p.orderedDone)
+
+	pending := make(map[int]*FlushChunkArgs) // 重排序缓冲区
+	for args := range p.completed {
+		pending[args.ChunkSn] = args
+		for {
+			next, ok := pending[nextSn]
+			if !ok {
+				break
+			}
+			delete(pending, nextSn)
+			nextSn++
+
+			if next.skipped {
+				continue
+			}
+			if !p.isFailed() {
+				if err := p.s.callOrderedFlushHandler(next); err != nil {
+					p.fail(err)
+				}
+			}
+			p.release(next)
+		}
+	}
+}
+
+func (p *flushPool) isFailed() bool {
+	select {
+	case <-p.failed:
+		return true
+	default:
+		return false
+	}
+}
+
+// 记录第一个错误并停止运行
+func (p *flushPool) fail(err error) {
+	p.errOnce.Do(func() {
+		p.err = err
+		close(p.failed)
+		p.cancel(err)
+
+		p.pendingMu.Lock()
+		p.pendingCond.Broadcast()
+		p.pendingMu.Unlock()
+	})
+}
+
+// 提交一个 chunk, 会阻塞直到有空闲的 worker. 积压达到 MaxPendingChunks 或 MaxPendingBytes 时会先等待积压减少
+func (p *flushPool) submit(args *FlushChunkArgs) {
+	args.pendingLen = pendingChunkSize(args)
+	p.pendingMu.Lock()
+	for p.isBacklogFull(args.pendingLen) && !p.isFailed() {
+		p.pendingCond.Wait()
+	}
+	p.pendingChunks++
+	p.pendingBytes += args.pendingLen
+	p.s.pendingChunkNum.Store(int64(p.pendingChunks))
+	p.s.pendingByteNum.Store(p.pendingBytes)
+	p.s.stats.PendingChunkPeak = max(p.s.stats.PendingChunkPeak, p.pendingChunks)
+	p.s.stats.PendingBytePeak = max(p.s.stats.PendingBytePeak, p.pendingBytes)
+	p.pendingMu.Unlock()
+
+	p.jobs <- args
+}
+
+// 再提交一个长度为 size 的 chunk 是否会超过积压限制, 没有积压时总是允许提交, 避免单个超大的 chunk 永远无法提交
+func (p *flushPool) isBacklogFull(size int64) bool {
+	if p.pendingChunks == 0 {
+		return false
+	}
+	if p.s.maxPendingChunks > 0 && p.pendingChunks >= p.s.maxPendingChunks {
+		return true
+	}
+	return p.s.maxPendingBytes > 0 && p.pendingBytes+size > int64(p.s.maxPendingBytes)
+}
+
+// chunk 处理完成, 从积压中移除并删除没有被取走的 spill 文件
+func (p *flushPool) release(args *FlushChunkArgs) {
+	args.releaseSpill()
+	p.pendingMu.Lock()
+	p.pendingChunks--
+	p.pendingBytes -= args.pendingLen
+	p.s.pendingChunkNum.Store(int64(p.pendingChunks))
+	p.s.pendingByteNum.Store(p.pendingBytes)
+	p.pendingCond.Signal()
+	p.pendingMu.Unlock()
+}
+
+// 计入积压的 chunk 数据长度, 开启 OmitChunkData 时为所有 value 的长度之和
+func pendingChunkSize(args *FlushChunkArgs) int64 {
+	if args.ChunkData != nil {
+		return int64(len(args.ChunkData))
+	}
+	var n int64
+	for _, v := range args.Values {
+		n += int64(len(v))
+	}
+	return n
+}
+
+// 跳过一个被过滤的 chunk, 按顺序处理时需要占用它的 ChunkSn
+func (p *flushPool) skip(chunkSn int) {
+	if p.completed != nil {
+		p.completed <- &FlushChunkArgs{ChunkSn: chunkSn, skipped: true}
+	}
+}
+
+// 等待所有已提交的 chunk 处理完成, 返回第一个错误
+func (p *flushPool) wait() error {
+	close(p.jobs)
+	p.wg.Wait()
+	if p.completed != nil {
+		close(p.completed)
+		<-p.orderedDone
+	}
+	return p.err
+}
@@ -0,0 +1,232 @@
+package splitter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 生成 n 个 value, 每个 value 包含自己的序号
+func numberedInput(n int) string {
+	var sb strings.Builder
+	for i := range n {
+		fmt.Fprintf(&sb, "value-%05d\n", i)
+	}
+	return sb.String()
+}
+
+func TestOrderedFlushConcurrency(t *testing.T) {
+	input := numberedInput(2000)
+	for _, concurrency := range []int{2, 4, 16} {
+		var mx sync.Mutex
+		handled := make(map[int]string)
+		var ordered []int
+		var out bytes.Buffer
+		s := NewSplitter(Conf{
+			Delim:            []byte("\n"),
+			ChunkSizeLimit:   100,
+			FlushConcurrency: concurrency,
+			OrderedFlush:     true,
+			FlushChunkHandler: func(args *FlushChunkArgs) error {
+				time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+				mx.Lock()
+				handled[args.ChunkSn] = string(args.ChunkData)
+				mx.Unlock()
+				return nil
+			},
+			OrderedFlushHandler: func(args *FlushChunkArgs) error {
+				ordered = append(ordered, args.ChunkSn)
+				// handler 已经返回
+				mx.Lock()
+				data, ok := handled[args.ChunkSn]
+				mx.Unlock()
+				if !ok || data != string(args.ChunkData) {
+					t.Errorf("chunk %d: OrderedFlushHandler called before FlushChunkHandler returned", args.ChunkSn)
+				}
+				out.Write(args.ChunkData)
+				out.WriteByte('\n')
+				return nil
+			},
+		})
+		if err := s.RunSplit(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		for i, sn := range ordered {
+			if sn != i {
+				t.Fatalf("concurrency %d: OrderedFlushHandler got chunk %d at position %d", concurrency, sn, i)
+			}
+		}
+		if len(ordered) != s.Stats().ChunkNum || len(handled) != len(ordered) {
+			t.Fatalf("concurrency %d: ordered %d, handled %d, ChunkNum %d", concurrency, len(ordered), len(handled), s.Stats().ChunkNum)
+		}
+		if out.String() != input {
+			t.Fatalf("concurrency %d: ordered output differs from input", concurrency)
+		}
+	}
+}
+
+func TestOrderedFlushConcurrencyError(t *testing.T) {
+	errFail := errors.New("fail")
+	var ordered []int
+	s := NewSplitter(Conf{
+		Delim:            []byte("\n"),
+		ChunkSizeLimit:   100,
+		FlushConcurrency: 4,
+		OrderedFlush:     true,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			if args.ChunkSn == 10 {
+				return errFail
+			}
+			return nil
+		},
+		OrderedFlushHandler: func(args *FlushChunkArgs) error {
+			ordered = append(ordered, args.ChunkSn)
+			return nil
+		},
+	})
+	if err := s.RunSplit(strings.NewReader(numberedInput(2000))); err != errFail {
+		t.Fatalf("got %v, want %v", err, errFail)
+	}
+	// 出错的 chunk 及之后的 chunk 不会按顺序提交
+	for i, sn := range ordered {
+		if sn != i || sn >= 10 {
+			t.Fatalf("OrderedFlushHandler got chunk %d at position %d", sn, i)
+		}
+	}
+}
+
+// 并发 flush 时 DisableChunkCopy 无效, handler 可以在返回后继续持有 ChunkData
+func TestDisableChunkCopyConcurrency(t *testing.T) {
+	input := numberedInput(1000)
+	var mx sync.Mutex
+	chunks := make(map[int][]byte)
+	s := NewSplitter(Conf{
+		Delim:            []byte("\n"),
+		ChunkSizeLimit:   100,
+		FlushConcurrency: 4,
+		DisableChunkCopy: true,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			mx.Lock()
+			chunks[args.ChunkSn] = args.ChunkData
+			mx.Unlock()
+			return nil
+		},
+	})
+	if err := s.RunSplit(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	for sn := range len(chunks) {
+		out.Write(chunks[sn])
+		out.WriteByte('\n')
+	}
+	if out.String() != input {
+		t.Fatal("chunk data was overwritten after the handler returned")
+	}
+}
+
+func benchmarkChunkCopy(b *testing.B, disableChunkCopy bool) {
+	input := []byte(strings.Repeat(strings.Repeat("x", 1023)+"\n", 16<<10))
+	s := NewSplitter(Conf{
+		Delim:             []byte("\n"),
+		ChunkSizeLimit:    1 << 20,
+		DisableChunkCopy:  disableChunkCopy,
+		FlushChunkHandler: WriterFlushChunkHandler(io.Discard, nil),
+	})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for range b.N {
+		if err := s.Reset(); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.RunSplit(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkChunkCopy(b *testing.B)        { benchmarkChunkCopy(b, false) }
+func BenchmarkDisableChunkCopy(b *testing.B) { benchmarkChunkCopy(b, true) }
+
+func TestMaxPendingChunksBackpressure(t *testing.T) {
+	const maxPending = 3
+	cases := []struct {
+		name string
+		conf Conf
+	}{
+		{"chunks", Conf{FlushConcurrency: 8, MaxPendingChunks: maxPending}},
+		{"chunks ordered", Conf{FlushConcurrency: 8, MaxPendingChunks: maxPending, OrderedFlush: true, OrderedFlushHandler: func(args *FlushChunkArgs) error {
+			time.Sleep(100 * time.Microsecond)
+			return nil
+		}}},
+		{"bytes", Conf{FlushConcurrency: 8, MaxPendingBytes: maxPending * 100}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var s Splitter
+			var mx sync.Mutex
+			var peakChunks int
+			var peakBytes int64
+			sample := func() {
+				n, size := s.PendingChunks()
+				mx.Lock()
+				peakChunks = max(peakChunks, n)
+				peakBytes = max(peakBytes, size)
+				mx.Unlock()
+			}
+			conf := c.conf
+			conf.Delim = []byte("\n")
+			conf.ChunkSizeLimit = 100
+			conf.FlushChunkHandler = func(args *FlushChunkArgs) error {
+				sample()
+				time.Sleep(time.Millisecond) // 比读取慢得多
+				return nil
+			}
+			s = NewSplitter(conf)
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					case <-time.After(50 * time.Microsecond):
+						sample()
+					}
+				}
+			}()
+			err := s.RunSplit(strings.NewReader(numberedInput(1000)))
+			close(stop)
+			wg.Wait()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats := s.Stats()
+			if c.conf.MaxPendingChunks > 0 {
+				if peakChunks > maxPending || stats.PendingChunkPeak > maxPending {
+					t.Fatalf("pending chunks exceeded %d: sampled %d, PendingChunkPeak %d", maxPending, peakChunks, stats.PendingChunkPeak)
+				}
+				if stats.PendingChunkPeak != maxPending {
+					t.Fatalf("PendingChunkPeak = %d, backpressure never reached", stats.PendingChunkPeak)
+				}
+			}
+			if limit := int64(c.conf.MaxPendingBytes); limit > 0 && (peakBytes > limit || stats.PendingBytePeak > limit) {
+				t.Fatalf("pending bytes exceeded %d: sampled %d, PendingBytePeak %d", limit, peakBytes, stats.PendingBytePeak)
+			}
+			if stats.ChunkNum == 0 {
+				t.Fatal("no chunks flushed")
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package splitter
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// 等待 s 扫描到 n 个字节
+func waitScanned(t *testing.T, s Splitter, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.ScanByteNum() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("scanned %d bytes, want %d", s.ScanByteNum(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlushReason(t *testing.T) {
+	// 写入 "a\n", 等待超过 wait 后再写入 "b\n"
+	slowFeed := func(wait time.Duration) func(*testing.T, Splitter, io.Writer) {
+		return func(t *testing.T, s Splitter, w io.Writer) {
+			_, _ = w.Write([]byte("a\n"))
+			waitScanned(t, s, 2)
+			time.Sleep(wait)
+			_, _ = w.Write([]byte("b\n"))
+		}
+	}
+	write := func(input string) func(*testing.T, Splitter, io.Writer) {
+		return func(t *testing.T, s Splitter, w io.Writer) { _, _ = w.Write([]byte(input)) }
+	}
+	cases := []struct {
+		name    string
+		conf    Conf
+		feed    func(t *testing.T, s Splitter, w io.Writer)
+		want    []FlushReason
+		wantErr error
+	}{
+		{"size limit", Conf{FlushPolicy: FlushPolicy{MaxBytes: 16}}, write("aaaaaaaaaa\nbbbbbbbbbb\nc"),
+			[]FlushReason{FlushReasonSizeLimit, FlushReasonEOF}, nil},
+		{"value limit", Conf{FlushPolicy: FlushPolicy{MaxBytes: 1024, MaxValues: 2}}, write("a\nb\nc"),
+			[]FlushReason{FlushReasonValueLimit, FlushReasonEOF}, nil},
+		{"age limit", Conf{FlushPolicy: FlushPolicy{MaxBytes: 1024, MaxAge: 20 * time.Millisecond}}, slowFeed(100 * time.Millisecond),
+			[]FlushReason{FlushReasonAgeLimit, FlushReasonEOF}, nil},
+		{"idle", Conf{IdleFlushInterval: 20 * time.Millisecond}, slowFeed(100 * time.Millisecond),
+			[]FlushReason{FlushReasonIdle, FlushReasonEOF}, nil},
+		{"eof", Conf{}, write("a\nb"),
+			[]FlushReason{FlushReasonEOF}, nil},
+		{"stopped", Conf{}, func(t *testing.T, s Splitter, w io.Writer) {
+			_, _ = w.Write([]byte("a\nb\n"))
+			waitScanned(t, s, 4)
+			s.StopAndFlush()
+		}, []FlushReason{FlushReasonStopped}, ErrSplitterIsStopped},
+		{"max value count", Conf{MaxValueCount: 2}, write("a\nb\nc\n"),
+			[]FlushReason{FlushReasonMaxValueCount}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var reasons []FlushReason
+			conf := c.conf
+			conf.Delim = []byte("\n")
+			conf.FlushChunkHandler = func(args *FlushChunkArgs) error {
+				reasons = append(reasons, args.FlushReason)
+				return nil
+			}
+			s := NewSplitter(conf)
+			pr, pw := io.Pipe()
+			runErr := make(chan error, 1)
+			go func() { runErr <- s.RunSplit(pr) }()
+			c.feed(t, s, pw)
+			pw.Close()
+
+			if err := <-runErr; !errors.Is(err, c.wantErr) {
+				t.Fatalf("got error %v, want %v", err, c.wantErr)
+			}
+			if len(reasons) != len(c.want) {
+				t.Fatalf("got reasons %v, want %v", reasons, c.want)
+			}
+			for i := range c.want {
+				if reasons[i] != c.want[i] {
+					t.Fatalf("got reasons %v, want %v", reasons, c.want)
+				}
+			}
+		})
+	}
+}
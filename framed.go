@@ -0,0 +1,73 @@
+package splitter
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+var ErrFramedChunkTooLarge = errors.New("framed chunk too large")
+
+// 每个 chunk 前写入的长度头的字节数
+const framedChunkHeaderSize = 4
+
+// 返回一个将 chunk 按帧写入 w 的 FlushChunkHandler, 每个 chunk 写入为 4 字节大端序的长度加上 ChunkData, 可以使用 FramedChunkReader 读取.
+// chunk 被 spill 时会从 SpillReader 复制数据.
+// 并发 flush 时帧之间不会交错, 但是顺序和 handler 的调用顺序一致, 需要按 ChunkSn 顺序写入时应在 OrderedFlushHandler 中使用
+func NewFramedChunkWriter(w io.Writer) FlushChunkHandler {
+	var mx sync.Mutex
+	return func(args *FlushChunkArgs) error {
+		rd, size := args.chunkReader()
+		if uint64(size) > math.MaxUint32 {
+			return fmt.Errorf("%w: chunkSn=%d, size=%d", ErrFramedChunkTooLarge, args.ChunkSn, size)
+		}
+		var header [framedChunkHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:], uint32(size))
+
+		mx.Lock()
+		defer mx.Unlock()
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, rd); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+// 读取 NewFramedChunkWriter 写入的 chunk
+type FramedChunkReader struct {
+	r            io.Reader
+	maxChunkSize int
+	header       [framedChunkHeaderSize]byte
+}
+
+// 创建一个帧读取器, maxChunkSize 限制单个 chunk 的长度, <=0 表示不限制. 读取不可信的数据时应设置它, 避免按错误的长度头分配过大的内存
+func NewFramedChunkReader(r io.Reader, maxChunkSize int) *FramedChunkReader {
+	return &FramedChunkReader{r: r, maxChunkSize: maxChunkSize}
+}
+
+// 读取下一个 chunk, 返回的数据可以安全持有. 在帧边界处读完时返回 io.EOF, 帧不完整时返回 io.ErrUnexpectedEOF,
+// chunk 长度超过 maxChunkSize 时返回 ErrFramedChunkTooLarge
+func (f *FramedChunkReader) Next() ([]byte, error) {
+	if _, err := io.ReadFull(f.r, f.header[:]); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(f.header[:]))
+	if f.maxChunkSize > 0 && size > int64(f.maxChunkSize) {
+		return nil, fmt.Errorf("%w: size=%d", ErrFramedChunkTooLarge, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,93 @@
+package splitter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// 通过 net.Pipe 写入并读取 chunk, 返回读取到的 chunk 数据
+func framedRoundTrip(t *testing.T, chunks []*FlushChunkArgs) [][]byte {
+	t.Helper()
+	w, r := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		h := NewFramedChunkWriter(w)
+		var err error
+		for _, args := range chunks {
+			if err = h(args); err != nil {
+				break
+			}
+		}
+		_ = w.Close()
+		errCh <- err
+	}()
+
+	var got [][]byte
+	fr := NewFramedChunkReader(r, 0)
+	for {
+		data, err := fr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got = append(got, data)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return got
+}
+
+func TestFramedChunkRoundTrip(t *testing.T) {
+	input := "a\nbb\nccc\ndddd\neeeee\nf"
+	want := mustCollectChunks(t, Conf{Delim: []byte("\n"), ChunkValueCountLimit: 2}, input)
+	got := framedRoundTrip(t, want)
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i].ChunkData) {
+			t.Errorf("chunk %d = %q, want %q", i, got[i], want[i].ChunkData)
+		}
+	}
+}
+
+func TestFramedChunkRoundTripEmptyLastChunk(t *testing.T) {
+	want := [][]byte{[]byte("a"), []byte("b,c"), {}}
+	chunks := make([]*FlushChunkArgs, len(want))
+	for i, data := range want {
+		chunks[i] = &FlushChunkArgs{ChunkSn: i, ChunkData: data, IsLastChunk: i == len(want)-1}
+	}
+	got := framedRoundTrip(t, chunks)
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("chunk %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFramedChunkReaderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewFramedChunkWriter(&buf)
+	if err := h(&FlushChunkArgs{ChunkData: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFramedChunkReader(bytes.NewReader(buf.Bytes()), 4).Next(); !errors.Is(err, ErrFramedChunkTooLarge) {
+		t.Errorf("maxChunkSize: got %v, want ErrFramedChunkTooLarge", err)
+	}
+	if _, err := NewFramedChunkReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), 0).Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: got %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := NewFramedChunkReader(bytes.NewReader(buf.Bytes()[:2]), 0).Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated header: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
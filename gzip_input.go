@@ -0,0 +1,45 @@
+package splitter
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// 创建一个读取 gzip 压缩数据的分隔器, 会覆盖 conf.DecompressGzip 为 true
+func NewGzipSplitter(conf Conf) Splitter {
+	conf.DecompressGzip = true
+	return NewSplitter(conf)
+}
+
+// 解压 gzip 数据的读取器. 创建 gzip.Reader 时会读取 gzip 头, 所以在第一次读取时才创建, 使错误可以通过 Read 返回
+type gzipInputReader struct {
+	rd  io.Reader
+	zr  *gzip.Reader
+	err error // 读取结束后总是返回这个错误
+}
+
+func (g *gzipInputReader) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	if g.zr == nil {
+		zr, err := gzip.NewReader(g.rd)
+		if err != nil {
+			g.err = err
+			return 0, err
+		}
+		g.zr = zr
+	}
+
+	n, err := g.zr.Read(p)
+	if err == io.EOF {
+		// 读取完成后关闭 gzip.Reader, 返回关闭时的错误
+		if cErr := g.zr.Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	if err != nil {
+		g.err = err
+	}
+	return n, err
+}
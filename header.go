@@ -0,0 +1,56 @@
+package splitter
+
+import (
+	"hash/crc32"
+)
+
+// 在 ChunkData 的开头和末尾写入 chunkHeader 和 chunkFooter 返回的数据, 并重新计算校验和
+func (s *splitter) decorateChunk(args *FlushChunkArgs) {
+	if (s.chunkHeader == nil && s.chunkFooter == nil) || s.omitChunkData {
+		return
+	}
+
+	var header, footer []byte
+	if s.chunkHeader != nil {
+		header = s.chunkHeader(args)
+	}
+	if s.chunkFooter != nil {
+		footer = s.chunkFooter(args)
+	}
+	if len(header) == 0 && len(footer) == 0 {
+		return
+	}
+
+	// 总是创建新的缓冲区, Values 仍然引用原来的缓冲区
+	bs := make([]byte, 0, len(header)+len(args.ChunkData)+len(footer))
+	bs = append(bs, header...)
+	bs = append(bs, args.ChunkData...)
+	bs = append(bs, footer...)
+	args.ChunkData = bs
+	s.stats.ChunkByteNum += int64(len(header) + len(footer))
+
+	if s.enableChecksum {
+		args.Checksum = crc32.ChecksumIEEE(bs)
+	} else if s.checksumFunc != nil {
+		args.Checksum = s.checksumFunc(bs)
+	}
+}
+
+// 加入下一个 value 后 chunk 头部和尾部的长度
+func (s *splitter) headerFooterLen() int {
+	args := &FlushChunkArgs{
+		ChunkSn:      s.chunkSn,
+		Partition:    s.partition,
+		StartValueSn: s.chunkStartValueSn,
+		EndValueSn:   s.nextValueSn,
+		ValueCount:   s.chunkValueNum + 1,
+	}
+	n := 0
+	if s.chunkHeader != nil {
+		n += len(s.chunkHeader(args))
+	}
+	if s.chunkFooter != nil {
+		n += len(s.chunkFooter(args))
+	}
+	return n
+}
@@ -0,0 +1,43 @@
+package splitter
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 分隔 data, 等同于使用 conf 创建分隔器后对 data 调用 RunSplit
+func SplitBytes(data []byte, conf Conf) error {
+	return NewSplitter(conf).RunSplit(bytes.NewReader(data))
+}
+
+// 分隔 s, 等同于使用 conf 创建分隔器后对 s 调用 RunSplit
+func SplitString(s string, conf Conf) error {
+	return NewSplitter(conf).RunSplit(strings.NewReader(s))
+}
+
+// 分隔 rd 并按 ChunkSn 顺序返回所有 chunk, 每个 chunk 的 ChunkData 都是独立的副本. 适用于测试或数据量较小的场景.
+// conf 中的 FlushChunkHandler, FlushChunkCtxHandler 和 FlushChunkStreamHandler 会被忽略, DisableChunkCopy, PoolChunkData 和 SpillThreshold 不会生效.
+// 出错时同时返回出错前已 flush 的 chunk
+func CollectChunks(rd io.Reader, conf Conf) ([]*FlushChunkArgs, error) {
+	var mx sync.Mutex
+	var chunks []*FlushChunkArgs
+	conf.FlushChunkStreamHandler = nil
+	conf.FlushChunkCtxHandler = nil
+	conf.DisableChunkCopy = false
+	conf.PoolChunkData = false
+	conf.SpillThreshold = 0
+	conf.FlushChunkHandler = func(args *FlushChunkArgs) error {
+		mx.Lock()
+		chunks = append(chunks, args)
+		mx.Unlock()
+		return nil
+	}
+
+	err := NewSplitter(conf).RunSplit(rd)
+	// 并发 flush 时 handler 的调用顺序是不确定的
+	slices.SortFunc(chunks, func(a, b *FlushChunkArgs) int { return a.ChunkSn - b.ChunkSn })
+	return chunks, err
+}
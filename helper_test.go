@@ -0,0 +1,59 @@
+package splitter
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 使用 CollectChunks 分隔 input, 出错时测试失败
+func mustCollectChunks(t *testing.T, conf Conf, input string) []*FlushChunkArgs {
+	t.Helper()
+	chunks, err := CollectChunks(strings.NewReader(input), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+// 和 CollectChunks 一样按 ChunkSn 顺序收集 chunk, 但是由 run 运行分隔器, 用于 RunSplitParallel 或者运行中调用 Stop 等场景.
+// conf 中设置了 FlushChunkHandler 时收集 chunk 后会再调用它
+func collectChunksWith(conf Conf, run func(s Splitter) error) ([]*FlushChunkArgs, error) {
+	var mx sync.Mutex
+	var chunks []*FlushChunkArgs
+	handler := conf.FlushChunkHandler
+	conf.FlushChunkHandler = func(args *FlushChunkArgs) error {
+		mx.Lock()
+		chunks = append(chunks, args)
+		mx.Unlock()
+		if handler != nil {
+			return handler(args)
+		}
+		return nil
+	}
+
+	err := run(NewSplitter(conf))
+	slices.SortFunc(chunks, func(a, b *FlushChunkArgs) int { return a.ChunkSn - b.ChunkSn })
+	return chunks, err
+}
+
+// 每个 chunk 的数据
+func chunkStrings(chunks []*FlushChunkArgs) []string {
+	out := make([]string, len(chunks))
+	for i, args := range chunks {
+		out[i] = string(args.ChunkData)
+	}
+	return out
+}
+
+// 按顺序返回所有 chunk 的 Values, 需要开启 IncludeValues
+func chunkValueStrings(chunks []*FlushChunkArgs) []string {
+	var out []string
+	for _, args := range chunks {
+		for _, v := range args.Values {
+			out = append(out, string(v))
+		}
+	}
+	return out
+}
@@ -0,0 +1,8 @@
+package splitter
+
+// 使用 chunkJoiner 将 value 写入临时缓冲区, 返回写入的数据, 在下次调用前有效
+func (s *splitter) joinValue(value []byte, index int) []byte {
+	s.joinBuffer.Reset()
+	s.chunkJoiner(&s.joinBuffer, value, index)
+	return s.joinBuffer.Bytes()
+}
@@ -0,0 +1,86 @@
+package splitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// 直接写入 value 的 joiner 和不设置 joiner 的结果一致
+func TestChunkJoinerIdentity(t *testing.T) {
+	identity := func(dst *bytes.Buffer, value []byte, index int) { dst.Write(value) }
+	rnd := rand.New(rand.NewSource(1))
+	confs := []Conf{
+		{Delim: []byte("\n"), ChunkSizeLimit: 16, IncludeValues: true},
+		{Delim: []byte("\n"), ChunkSizeLimit: 40, IncludeValues: true, KeepTrailingDelim: true},
+		{Delim: []byte("\r\n"), ChunkSizeLimit: 32, IncludeValues: true, OutputDelim: []byte("|")},
+		{Delim: []byte("\n"), ChunkSizeLimit: 24, IncludeValues: true, MinLastChunkSize: 10},
+		{Delim: []byte("\n"), ChunkSizeLimit: 1024, IncludeValues: true, ChunkValueCountLimit: 3},
+	}
+	for i, conf := range confs {
+		for range 50 {
+			values := make([]string, rnd.Intn(40))
+			for j := range values {
+				values[j] = strings.Repeat(string(rune('a'+j%26)), 1+rnd.Intn(20))
+			}
+			input := strings.Join(values, string(conf.Delim))
+			want := mustCollectChunks(t, conf, input)
+			conf.ChunkJoiner = identity
+			got := mustCollectChunks(t, conf, input)
+			conf.ChunkJoiner = nil
+			gotChunks, wantChunks := chunkStrings(got), chunkStrings(want)
+			if strings.Join(gotChunks, "#") != strings.Join(wantChunks, "#") || strings.Join(chunkValueStrings(got), "#") != strings.Join(chunkValueStrings(want), "#") {
+				t.Fatalf("conf %d, input %q: got %q, want %q", i, input, gotChunks, wantChunks)
+			}
+		}
+	}
+}
+
+func TestChunkJoinerCalledOncePerValue(t *testing.T) {
+	var calls []string
+	quote := func(dst *bytes.Buffer, value []byte, index int) {
+		calls = append(calls, string(value))
+		dst.WriteByte('"')
+		dst.Write(value)
+		dst.WriteByte('"')
+	}
+	got := mustCollectChunks(t, Conf{
+		Delim:          []byte("\n"),
+		OutputDelim:    []byte(","),
+		ChunkSizeLimit: MinChunkSizeLimit,
+		IncludeValues:  true,
+		ChunkJoiner:    quote,
+	}, "aaaa\nbbbb\ncccc\ndddddddddddddddddddd\ne")
+	chunks, values := chunkStrings(got), chunkValueStrings(got)
+	// 每个 value 只调用一次, 包括加入时需要先 flush 的 value
+	if strings.Join(calls, ",") != "aaaa,bbbb,cccc,dddddddddddddddddddd,e" {
+		t.Fatalf("joiner calls %q", calls)
+	}
+	want := []string{`"aaaa","bbbb"`, `"cccc"`, `"dddddddddddddddddddd"`, `"e"`}
+	if strings.Join(chunks, "#") != strings.Join(want, "#") {
+		t.Fatalf("got chunks %q, want %q", chunks, want)
+	}
+	if len(values) != 5 || values[0] != `"aaaa"` || values[4] != `"e"` {
+		t.Fatalf("got values %q", values)
+	}
+}
+
+// JSON 数组格式加入 value 前需要 flush 时, 复用的 value 作为下一个 chunk 的第一个 value
+func TestChunkFormatJSONArrayFlushMidValue(t *testing.T) {
+	chunks := chunkStrings(mustCollectChunks(t, Conf{
+		Delim:          []byte("\n"),
+		ChunkFormat:    ChunkFormatJSONArray,
+		ChunkSizeLimit: 20,
+	}, "aaaa\nbbbb\ncccc\ndddd\ne"))
+	want := []string{`["aaaa","bbbb"]`, `["cccc","dddd","e"]`}
+	if strings.Join(chunks, "#") != strings.Join(want, "#") {
+		t.Fatalf("got %q, want %q", chunks, want)
+	}
+	for _, c := range chunks {
+		if len(c) > 20 || !json.Valid([]byte(c)) {
+			t.Fatalf("invalid chunk %q", c)
+		}
+	}
+}
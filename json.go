@@ -0,0 +1,85 @@
+package splitter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var ErrInvalidUTF8Value = errors.New("value is not valid utf-8")
+
+// chunk 数据的格式
+type ChunkFormat int
+
+const (
+	// value 之间使用分隔符连接
+	ChunkFormatRaw ChunkFormat = iota
+	// JSON 字符串数组, 例如 ["v1","v2"]
+	ChunkFormatJSONArray
+)
+
+const hexDigits = "0123456789abcdef"
+
+// 将 value 作为 JSON 字符串写入 JSON 数组, 第一个 value 前写入 "[", 之后的 value 前写入 ","
+func (s *splitter) appendJSONValue(dst *bytes.Buffer, value []byte, index int) {
+	if index == 0 {
+		dst.WriteByte('[')
+	} else {
+		dst.WriteByte(',')
+	}
+	appendJSONString(dst, value)
+}
+
+// 检查 value 是否可以写入 JSON 数组, 开启 rejectInvalidUTF8 时不是合法的 UTF-8 会返回 ErrInvalidUTF8Value.
+// err 为读取 value 时返回的错误, 没有问题时原样返回
+func (s *splitter) checkJSONValue(value []byte, err error) error {
+	if s.rejectInvalidUTF8 && !utf8.Valid(value) {
+		return fmt.Errorf("%w: valueSn=%d", ErrInvalidUTF8Value, s.nextValueSn)
+	}
+	return err
+}
+
+// 将 value 编码为 JSON 字符串写入 dst. 转义 '"', '\' 和控制字符, 不合法的 UTF-8 字节会被替换为 \ufffd
+func appendJSONString(dst *bytes.Buffer, value []byte) {
+	dst.WriteByte('"')
+	start := 0
+	for i := 0; i < len(value); {
+		if b := value[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			dst.Write(value[start:i])
+			switch b {
+			case '"', '\\':
+				dst.WriteByte('\\')
+				dst.WriteByte(b)
+			case '\n':
+				dst.WriteString(`\n`)
+			case '\r':
+				dst.WriteString(`\r`)
+			case '\t':
+				dst.WriteString(`\t`)
+			default:
+				dst.WriteString(`\u00`)
+				dst.WriteByte(hexDigits[b>>4])
+				dst.WriteByte(hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRune(value[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst.Write(value[start:i])
+			dst.WriteString(`\ufffd`)
+			i++
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst.Write(value[start:])
+	dst.WriteByte('"')
+}
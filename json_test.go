@@ -0,0 +1,73 @@
+package splitter
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChunkFormatJSONArrayValues(t *testing.T) {
+	values := []string{
+		`plain`,
+		`say "hi"`,
+		`back\slash`,
+		`{"k":"v"}`,
+		"tab\tand\x01ctrl",
+		"bad\xffutf8\xc3",
+		"中文",
+	}
+	for _, omit := range []bool{false, true} {
+		got := mustCollectChunks(t, Conf{
+			Delim:          []byte("\n"),
+			ChunkFormat:    ChunkFormatJSONArray,
+			ChunkSizeLimit: 40,
+			IncludeValues:  true,
+			OmitChunkData:  omit,
+		}, strings.Join(values, "\n"))
+		chunks, gotValues := chunkStrings(got), chunkValueStrings(got)
+		// Values 是原始的 value, 不会被编码
+		if len(gotValues) != len(values) {
+			t.Fatalf("omit %v: got %d values, want %d", omit, len(gotValues), len(values))
+		}
+		for i := range values {
+			if gotValues[i] != values[i] {
+				t.Errorf("omit %v: value %d = %q, want %q", omit, i, gotValues[i], values[i])
+			}
+		}
+		if omit {
+			continue
+		}
+
+		// ChunkData 和 encoding/json 的解码结果一致, 不合法的 UTF-8 被替换为 �
+		var decoded []string
+		for _, chunk := range chunks {
+			var arr []string
+			if err := json.Unmarshal([]byte(chunk), &arr); err != nil {
+				t.Fatalf("chunk %q: %v", chunk, err)
+			}
+			decoded = append(decoded, arr...)
+		}
+		for i, v := range values {
+			b, _ := json.Marshal(v)
+			var want string
+			_ = json.Unmarshal(b, &want)
+			if decoded[i] != want {
+				t.Errorf("decoded value %d = %q, want %q", i, decoded[i], want)
+			}
+		}
+	}
+}
+
+func TestChunkFormatJSONArrayRejectInvalidUTF8(t *testing.T) {
+	s := NewSplitter(Conf{
+		Delim:             []byte("\n"),
+		ChunkFormat:       ChunkFormatJSONArray,
+		RejectInvalidUTF8: true,
+		FlushChunkHandler: func(args *FlushChunkArgs) error { return nil },
+	})
+	err := s.RunSplit(strings.NewReader("ok\n\"quoted\\\"\nbad\xff"))
+	if !errors.Is(err, ErrInvalidUTF8Value) {
+		t.Fatalf("got %v, want ErrInvalidUTF8Value", err)
+	}
+}
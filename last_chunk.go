@@ -0,0 +1,98 @@
+package splitter
+
+import (
+	"bytes"
+	"hash/crc32"
+)
+
+// 是否延迟 flush 一个 chunk, 开启 minLastChunkSize 或 lookaheadLastChunk 时需要
+func (s *splitter) holdsChunk() bool {
+	return s.minLastChunkSize > 0 || s.lookaheadLastChunk
+}
+
+// 延迟 flush 一个 chunk, 收到下一个 chunk 时才 flush 上一个 chunk.
+// 如果最后一个 chunk 的长度小于 minLastChunkSize, 会合并到上一个 chunk 中一起 flush
+func (s *splitter) holdChunk(args *FlushChunkArgs) error {
+	prev := s.pendingChunk
+	if !args.IsLastChunk {
+		s.pendingChunk = args
+		if prev == nil {
+			return nil
+		}
+		return s.dispatchChunk(prev)
+	}
+
+	s.pendingChunk = nil
+	if prev == nil {
+		return s.dispatchChunk(args)
+	}
+	if len(s.chunkBytes(args)) >= s.minLastChunkSize {
+		if err := s.dispatchChunk(prev); err != nil {
+			return err
+		}
+		return s.dispatchChunk(args)
+	}
+	return s.dispatchChunk(s.mergeChunk(prev, args))
+}
+
+// 运行结束时 flush 延迟的 chunk. 最后一个 chunk 因为 EOF 等原因 flush 时它已经被处理了,
+// 这里在停止或出错时, 或者 EOF 时缓冲区为空时生效. isLast 表示是否正常结束
+func (s *splitter) flushPendingChunk(isLast bool) error {
+	prev := s.pendingChunk
+	if prev == nil {
+		return nil
+	}
+	s.pendingChunk = nil
+	prev.IsLastChunk = prev.IsLastChunk || isLast
+	return s.dispatchChunk(prev)
+}
+
+// 获取 chunk 数据, 开启 omitChunkData 时 ChunkData 为 nil, 需要通过 Values 重新拼接
+func (s *splitter) chunkBytes(args *FlushChunkArgs) []byte {
+	if !s.omitChunkData {
+		return args.ChunkData
+	}
+	data := bytes.Join(args.Values, s.outputDelim)
+	if args.DelimSuffix {
+		data = append(data, s.outputDelim...)
+	}
+	return data
+}
+
+// 将最后一个 chunk 合并到上一个 chunk 中
+func (s *splitter) mergeChunk(prev, last *FlushChunkArgs) *FlushChunkArgs {
+	// 保留末尾分隔符时上一个 chunk 已经以分隔符结尾
+	sep := s.outputDelim
+	if prev.DelimSuffix {
+		sep = nil
+	}
+	tail := s.chunkBytes(last)
+	if !s.omitChunkData {
+		prev.ChunkData = append(append(prev.ChunkData, sep...), tail...)
+		if prev.pooledData != nil {
+			*prev.pooledData = prev.ChunkData
+		}
+	}
+	prev.Values = append(prev.Values, last.Values...)
+	prev.ValueDelims = append(prev.ValueDelims, last.ValueDelims...)
+	prev.EndValueSn = last.EndValueSn
+	prev.ValueCount += last.ValueCount
+	prev.ScanByteNum = last.ScanByteNum
+	prev.EndOffset = last.EndOffset
+	prev.IsLastChunk = last.IsLastChunk
+	prev.IsStopped = last.IsStopped
+	prev.FlushReason = last.FlushReason
+	prev.SizeExceeded = prev.SizeExceeded || last.SizeExceeded
+	prev.DelimSuffix = last.DelimSuffix
+	if s.enableChecksum {
+		prev.Checksum = crc32.Update(prev.Checksum, crc32.IEEETable, sep)
+		prev.Checksum = crc32.Update(prev.Checksum, crc32.IEEETable, tail)
+	} else if s.checksumFunc != nil {
+		prev.Checksum = s.checksumFunc(s.chunkBytes(prev))
+	}
+
+	// 合并后少了一个 chunk
+	s.chunkSn--
+	s.stats.ChunkByteNum += int64(len(sep))
+	return prev
+}
@@ -0,0 +1,7 @@
+package splitter
+
+// 创建一个按行分隔的分隔器, 会覆盖 conf.Delim 为 "\n". 处理 CRLF 文件时可以开启 conf.TrimCR 去掉每行末尾的 "\r"
+func NewLineSplitter(conf Conf) Splitter {
+	conf.Delim = []byte("\n")
+	return NewSplitter(conf)
+}
@@ -0,0 +1,21 @@
+package splitter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrValueContainsOutputDelim = errors.New("value contains output delim")
+
+// 检查 value 中是否包含 outputDelim, 设置了 outputDelimEscape 时替换为它, 否则返回 ErrValueContainsOutputDelim.
+// err 为读取 value 时返回的错误, 没有问题时原样返回
+func (s *splitter) escapeOutputDelim(value []byte, err error) ([]byte, error) {
+	if !bytes.Contains(value, s.outputDelim) {
+		return value, err
+	}
+	if len(s.outputDelimEscape) == 0 {
+		return nil, fmt.Errorf("%w: valueSn=%d", ErrValueContainsOutputDelim, s.nextValueSn)
+	}
+	return bytes.ReplaceAll(value, s.outputDelim, s.outputDelimEscape), err
+}
@@ -0,0 +1,92 @@
+package splitter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+var ErrHandlerPanic = errors.New("handler panic")
+
+// FlushChunkHandler, FlushChunkStreamHandler, OrderedFlushHandler, ChunkFilter 或 ValueFilter 发生 panic 时返回的错误, errors.Is(err, ErrHandlerPanic) 为 true
+type HandlerPanicError struct {
+	Value   any    // panic 的值
+	Stack   []byte // panic 时的调用栈
+	ChunkSn int    // 在 FlushChunkHandler, OrderedFlushHandler 或 ChunkFilter 中 panic 时为 chunk sn, 否则为 -1
+	ValueSn int64  // 在 ValueFilter 中 panic 时为 value sn, 否则为 -1
+}
+
+func (e *HandlerPanicError) Error() string {
+	if e.ChunkSn >= 0 {
+		return fmt.Sprintf("handler panic: flush handler panic at chunkSn=%d: %v", e.ChunkSn, e.Value)
+	}
+	return fmt.Sprintf("handler panic: ValueFilter panic at valueSn=%d: %v", e.ValueSn, e.Value)
+}
+
+func (e *HandlerPanicError) Is(target error) bool {
+	return target == ErrHandlerPanic
+}
+
+// 调用 flushChunkHandler, 未禁用时会将 panic 转为 *HandlerPanicError
+func (s *splitter) callFlushChunkHandler(args *FlushChunkArgs) (err error) {
+	if !s.disablePanicRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				err = &HandlerPanicError{Value: e, Stack: debug.Stack(), ChunkSn: args.ChunkSn, ValueSn: -1}
+			}
+		}()
+	}
+	return s.flushChunkHandler(args)
+}
+
+// 调用 flushChunkStreamHandler, 未禁用时会将 panic 转为 *HandlerPanicError
+func (s *splitter) callFlushChunkStreamHandler(args *FlushChunkArgs, r io.Reader) (err error) {
+	if !s.disablePanicRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				err = &HandlerPanicError{Value: e, Stack: debug.Stack(), ChunkSn: args.ChunkSn, ValueSn: -1}
+			}
+		}()
+	}
+	return s.flushChunkStreamHandler(args, r)
+}
+
+// 调用 orderedFlushHandler, 未禁用时会将 panic 转为 *HandlerPanicError
+func (s *splitter) callOrderedFlushHandler(args *FlushChunkArgs) (err error) {
+	if !s.disablePanicRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				err = &HandlerPanicError{Value: e, Stack: debug.Stack(), ChunkSn: args.ChunkSn, ValueSn: -1}
+			}
+		}()
+	}
+	return s.orderedFlushHandler(args)
+}
+
+// 调用 chunkFilter, 未禁用时会将 panic 转为 *HandlerPanicError
+func (s *splitter) callChunkFilter(args *FlushChunkArgs) (keep bool, err error) {
+	if !s.disablePanicRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				err = &HandlerPanicError{Value: e, Stack: debug.Stack(), ChunkSn: args.ChunkSn, ValueSn: -1}
+			}
+		}()
+	}
+	return s.chunkFilter(args), nil
+}
+
+// 调用 valueFilter, 未禁用时会将 panic 转为 *HandlerPanicError. 过滤器返回的错误会包装上 ErrValueFilter, value sn 和已扫描的字节数
+func (s *splitter) callValueFilter(sn int64, value []byte) (ret []byte, err error) {
+	if !s.disablePanicRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				err = &HandlerPanicError{Value: e, Stack: debug.Stack(), ChunkSn: -1, ValueSn: sn}
+			}
+		}()
+	}
+	if ret, err = s.valueFilter(sn, value); err != nil {
+		return nil, fmt.Errorf("%w: valueSn=%d, scanByteNum=%d: %w", ErrValueFilter, sn, s.ScanByteNum(), err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,317 @@
+package splitter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrParallelUnsupported = errors.New("conf is not supported by RunSplitParallel")
+
+// 并行分隔时每个范围最多缓冲的 chunk 数, 超过后这个范围会等待前面的范围处理完成
+const parallelChunkBufSize = 16
+
+// 并行分隔的一个范围
+type parallelRange struct {
+	start   int64
+	end     int64
+	s       *splitter
+	chunkCh chan *FlushChunkArgs
+	errCh   chan error
+}
+
+func (s *splitter) RunSplitParallel(ra io.ReaderAt, size int64, workers int) (err error) {
+	if err := s.checkParallelConf(); err != nil {
+		return err
+	}
+	// 防止重复调用
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return ErrSplitterIsStarted
+	}
+
+	var scanByteNum int64
+	ctx, end := s.beginContext(context.Background(), func() int64 { return scanByteNum })
+	defer func() { end(err) }()
+
+	if s.flushConcurrency > 1 && s.chunkCh == nil {
+		s.pool = newFlushPool(s, s.flushConcurrency, s.orderedFlushHandler, *s.cancel.Load())
+		defer func() {
+			if pErr := s.pool.wait(); pErr != nil {
+				err = pErr
+			}
+			s.pool = nil
+		}()
+	}
+	if s.holdsChunk() {
+		defer func() {
+			if pErr := s.flushPendingChunk(err == nil); pErr != nil && err == nil {
+				err = pErr
+			}
+		}()
+	}
+
+	if s.onStart != nil {
+		if err = s.onStart(); err != nil {
+			return err
+		}
+	}
+
+	bounds, err := splitRanges(ra, size, workers, s.delimiter)
+	if err != nil {
+		return err
+	}
+
+	// 结束时中断所有范围的分隔并等待它们退出
+	workerCtx, cancelWorkers := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancelWorkers()
+		wg.Wait()
+	}()
+
+	ranges := make([]*parallelRange, 0, len(bounds)-1)
+	for i := 1; i < len(bounds); i++ {
+		r, err := s.newParallelRange(bounds[i-1], bounds[i])
+		if err != nil {
+			return err
+		}
+		ranges = append(ranges, r)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := r.s.run(workerCtx, io.NewSectionReader(ra, r.start, r.end-r.start))
+			close(r.chunkCh)
+			r.errCh <- err
+		}()
+	}
+
+	// 按范围的顺序处理 chunk, 范围末尾的 chunk 需要等到之后还有 chunk 时才能确定它不是最后一个 chunk
+	var held *FlushChunkArgs
+	var valueSn int64 // 当前范围第一个 value 的 sn
+	// StopAndFlush 时 held 也是已缓冲的数据, 需要作为停止时的最后一个 chunk flush
+	checkStop := func() error {
+		err := s.checkStop(ctx, scanByteNum)
+		if err == ErrSplitterIsStopped && held != nil && atomic.LoadInt32(&s.stopFlush) > 0 {
+			held.IsStopped = true
+			held.FlushReason = FlushReasonStopped
+			if fErr := s.emitParallelChunk(held); fErr != nil {
+				return fErr
+			}
+			held = nil
+		}
+		return err
+	}
+	for _, r := range ranges {
+		for args := range r.chunkCh {
+			s.waitResume(ctx)
+			if err := checkStop(); err != nil {
+				return err
+			}
+			args.StartValueSn += valueSn
+			args.EndValueSn += valueSn
+			args.StartOffset += r.start
+			args.EndOffset += r.start
+			args.ScanByteNum += r.start
+
+			if held != nil {
+				held.IsLastChunk = false
+				if err := s.emitParallelChunk(held); err != nil {
+					return err
+				}
+				held = nil
+			}
+			if args.IsLastChunk {
+				held = args
+				continue
+			}
+			if err := s.emitParallelChunk(args); err != nil {
+				return err
+			}
+		}
+		if rErr := <-r.errCh; rErr != nil {
+			if err := checkStop(); err != nil {
+				return err
+			}
+			return rErr
+		}
+
+		stats := r.s.stats
+		valueSn += stats.ValueNum
+		scanByteNum = r.end
+		s.nextValueSn = valueSn
+		s.stats.ChunkByteNum += stats.ChunkByteNum
+		s.stats.ScanValueNum += stats.ScanValueNum
+		s.stats.ValueNum += stats.ValueNum
+		s.stats.DiscardedValueNum += stats.DiscardedValueNum
+		s.stats.MaxValueSize = max(s.stats.MaxValueSize, stats.MaxValueSize)
+	}
+	if held != nil {
+		return s.emitParallelChunk(held)
+	}
+	return nil
+}
+
+// 按全局顺序为 chunk 分配 ChunkSn 并 flush
+func (s *splitter) emitParallelChunk(args *FlushChunkArgs) error {
+	args.ChunkSn = s.chunkSn
+	s.chunkSn++
+	if s.holdsChunk() {
+		return s.holdChunk(args)
+	}
+	return s.dispatchChunk(args)
+}
+
+// 为 [start, end) 创建分隔器, 只负责读取和构建 chunk, chunk 的后续处理由调用者完成
+func (s *splitter) newParallelRange(start, end int64) (*parallelRange, error) {
+	conf := s.conf
+	conf.FlushChunkHandler = nil
+	conf.FlushChunkCtxHandler = nil
+	conf.OrderedFlush = false
+	conf.OrderedFlushHandler = nil
+	conf.FlushConcurrency = 0
+	conf.ChunkFilter = nil
+	conf.ChunkHeader = nil
+	conf.ChunkFooter = nil
+	conf.CompressChunks = false
+	conf.EncodeChunkBase64 = false
+	conf.ChunkTransformers = nil
+	conf.MinLastChunkSize = 0
+	conf.Timeout = 0
+	conf.OnStart = nil
+	conf.OnFinish = nil
+	conf.ProgressHandler = nil
+	inner, err := NewSplitterE(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &parallelRange{
+		start:   start,
+		end:     end,
+		s:       inner.(*splitter),
+		chunkCh: make(chan *FlushChunkArgs, parallelChunkBufSize),
+		errCh:   make(chan error, 1),
+	}
+	r.s.started = 1
+	r.s.chunkCh = r.chunkCh
+	return r, nil
+}
+
+// 检查配置是否支持并行分隔, 这些配置依赖从头开始顺序读取
+func (s *splitter) checkParallelConf() error {
+	conf := s.conf
+	var name string
+	switch {
+	case conf.FlushChunkStreamHandler != nil:
+		name = "FlushChunkStreamHandler"
+	case conf.Follow:
+		name = "Follow"
+	case conf.DecompressGzip:
+		name = "DecompressGzip"
+	case conf.PartitionKey != nil:
+		name = "PartitionKey"
+	case conf.SkipValueCount > 0:
+		name = "SkipValueCount"
+	case conf.MaxValueCount > 0:
+		name = "MaxValueCount"
+	case conf.MaxChunkCount > 0:
+		name = "MaxChunkCount"
+	case conf.Dedup:
+		name = "Dedup"
+	case conf.CountFilteredValues:
+		name = "CountFilteredValues"
+	case conf.SpillThreshold > 0:
+		name = "SpillThreshold"
+	case conf.Quote != 0:
+		name = "Quote"
+	case conf.Escape != 0:
+		name = "Escape"
+	case conf.DelimMatch != nil:
+		name = "DelimMatch"
+	case hasDelimBorder(conf.Delim):
+		name = "self-overlapping Delim"
+	case conf.ValueSnFilter != nil:
+		name = "ValueSnFilter"
+	case conf.ValueFilterE != nil:
+		name = "ValueFilterE"
+	case conf.ValueHandler != nil:
+		name = "ValueHandler"
+	case conf.HeaderFooterInSizeLimit:
+		name = "HeaderFooterInSizeLimit"
+	case conf.RateLimit > 0 || s.rateLimit.Load() > 0:
+		name = "RateLimit"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrParallelUnsupported, name)
+}
+
+// delim 是否有真边界(既是前缀又是后缀的真子串), 例如 "aa" 和 "aba". 这样的分隔符可能和自身重叠,
+// 从任意位置开始查找到的分隔符可能和顺序读取时不同, 所以不能用来对齐范围
+func hasDelimBorder(delim []byte) bool {
+	for n := 1; n < len(delim); n++ {
+		if bytes.Equal(delim[:n], delim[len(delim)-n:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// 将 [0, size) 分为最多 workers 个范围, 返回每个范围的边界. 除了第一个范围, 每个范围都从一个分隔符之后开始
+func splitRanges(ra io.ReaderAt, size int64, workers int, delim []byte) ([]int64, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	size = max(size, 0)
+
+	bounds := []int64{0}
+	for i := 1; i < workers; i++ {
+		prev := bounds[len(bounds)-1]
+		pos := size * int64(i) / int64(workers)
+		if pos <= prev {
+			continue
+		}
+		pos, err := nextDelimEnd(ra, pos, size, delim)
+		if err != nil {
+			return nil, err
+		}
+		if pos >= size {
+			break
+		}
+		bounds = append(bounds, pos)
+	}
+	return append(bounds, size), nil
+}
+
+// 返回从 pos 开始的第一个分隔符结束的位置, 没有分隔符时返回 size
+func nextDelimEnd(ra io.ReaderAt, pos, size int64, delim []byte) (int64, error) {
+	r := bufio.NewReaderSize(io.NewSectionReader(ra, pos, size-pos), DefaultReadBufferSize)
+	last := delim[len(delim)-1]
+	window := make([]byte, 0, len(delim)) // 最后读取的 len(delim) 个字节
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos++
+
+		if len(window) == len(delim) {
+			copy(window, window[1:])
+			window = window[:len(delim)-1]
+		}
+		window = append(window, b)
+		if b == last && bytes.Equal(window, delim) {
+			return pos, nil
+		}
+	}
+}
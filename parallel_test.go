@@ -0,0 +1,157 @@
+package splitter
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunSplitParallelMatchesSequential(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, delim := range []string{"\n", "ab", "\r\n", "abc", "xab"} {
+		for i := 0; i < 300; i++ {
+			buf := make([]byte, rnd.Intn(200))
+			for j := range buf {
+				buf[j] = "abcx\r\n"[rnd.Intn(6)]
+			}
+			// 每个 value 作为一个 chunk
+			conf := Conf{Delim: []byte(delim), PerValueChunks: true}
+			var wantStats, gotStats Stats
+			wantChunks, err := collectChunksWith(conf, func(s Splitter) error {
+				defer func() { wantStats = s.Stats() }()
+				return s.RunSplit(bytes.NewReader(buf))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			workers := rnd.Intn(8) + 1
+			gotChunks, err := collectChunksWith(conf, func(s Splitter) error {
+				defer func() { gotStats = s.Stats() }()
+				return s.RunSplitParallel(bytes.NewReader(buf), int64(len(buf)), workers)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, chunks := range [][]*FlushChunkArgs{wantChunks, gotChunks} {
+				for i, args := range chunks {
+					if args.StartValueSn != int64(i) {
+						t.Fatalf("delim %q, input %q, workers %d: value %q: sn = %d, want %d", delim, buf, workers, args.ChunkData, args.StartValueSn, i)
+					}
+				}
+			}
+			got, want := chunkStrings(gotChunks), chunkStrings(wantChunks)
+			if len(got) != len(want) {
+				t.Fatalf("delim %q, input %q, workers %d: got %q, want %q", delim, buf, workers, got, want)
+			}
+			for j := range want {
+				if got[j] != want[j] {
+					t.Fatalf("delim %q, input %q, workers %d: got %q, want %q", delim, buf, workers, got, want)
+				}
+			}
+			if wantStats.ScanValueNum != wantStats.ValueNum+wantStats.DiscardedValueNum {
+				t.Fatalf("delim %q, input %q: ScanValueNum %d, ValueNum %d, DiscardedValueNum %d", delim, buf, wantStats.ScanValueNum, wantStats.ValueNum, wantStats.DiscardedValueNum)
+			}
+			if gotStats.ScanValueNum != wantStats.ScanValueNum || gotStats.DiscardedValueNum != wantStats.DiscardedValueNum {
+				t.Fatalf("delim %q, input %q, workers %d: got ScanValueNum %d, DiscardedValueNum %d, want %d, %d", delim, buf, workers,
+					gotStats.ScanValueNum, gotStats.DiscardedValueNum, wantStats.ScanValueNum, wantStats.DiscardedValueNum)
+			}
+		}
+	}
+}
+
+func TestRunSplitParallelRejectsSelfOverlappingDelim(t *testing.T) {
+	data := []byte("baxxxaaaabaabbaaaxbaxabxxx")
+	for _, delim := range []string{"aa", "aba", "abab", "abcab"} {
+		err := NewSplitter(Conf{Delim: []byte(delim)}).RunSplitParallel(bytes.NewReader(data), int64(len(data)), 4)
+		if !errors.Is(err, ErrParallelUnsupported) {
+			t.Errorf("delim %q: got %v, want ErrParallelUnsupported", delim, err)
+		}
+	}
+}
+
+func TestHasDelimBorder(t *testing.T) {
+	cases := map[string]bool{"\n": false, "\r\n": false, "ab": false, "abc": false, "aab": false, "aa": true, "aba": true, "abcab": true, "abab": true}
+	for delim, want := range cases {
+		if got := hasDelimBorder([]byte(delim)); got != want {
+			t.Errorf("hasDelimBorder(%q) = %v, want %v", delim, got, want)
+		}
+	}
+}
+
+// 从 at 开始读取时阻塞, 直到 release 关闭
+type blockingReaderAt struct {
+	data    []byte
+	at      int64
+	release chan struct{}
+}
+
+func (b *blockingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off == b.at {
+		<-b.release
+	}
+	return bytes.NewReader(b.data).ReadAt(p, off)
+}
+
+func TestRunSplitParallelStopAndFlush(t *testing.T) {
+	data := []byte("a\nb\nc\ndddddd\ne")
+	bounds, err := splitRanges(bytes.NewReader(data), int64(len(data)), 2, []byte("\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bounds) != 3 || string(data[:bounds[1]]) != "a\nb\nc\ndddddd\n" {
+		t.Fatalf("unexpected bounds %v", bounds)
+	}
+	// 第二个范围阻塞在第一次读取, 第一个范围的最后一个 chunk 会被保留等待之后的 chunk
+	ra := &blockingReaderAt{data: data, at: bounds[1], release: make(chan struct{})}
+	defer close(ra.release)
+
+	var mx sync.Mutex
+	var chunks []*FlushChunkArgs
+	s := NewSplitter(Conf{
+		Delim:          []byte("\n"),
+		PerValueChunks: true,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			mx.Lock()
+			chunks = append(chunks, args)
+			mx.Unlock()
+			return nil
+		},
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.RunSplitParallel(ra, int64(len(data)), 2) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mx.Lock()
+		n := len(chunks)
+		mx.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("chunks of the first range not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	s.StopAndFlush()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrSplitterIsStopped) {
+			t.Fatalf("got %v, want ErrSplitterIsStopped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunSplitParallel not returned after StopAndFlush")
+	}
+	if got := chunkStrings(chunks); strings.Join(got, ",") != "a,b,c,dddddd" {
+		t.Fatalf("got chunks %q", got)
+	}
+	last := chunks[len(chunks)-1]
+	if !last.IsStopped || !last.IsLastChunk || last.FlushReason != FlushReasonStopped || last.ChunkSn != 3 {
+		t.Fatalf("last chunk: IsStopped %v, IsLastChunk %v, FlushReason %v, ChunkSn %d", last.IsStopped, last.IsLastChunk, last.FlushReason, last.ChunkSn)
+	}
+}
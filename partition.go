@@ -0,0 +1,115 @@
+package splitter
+
+import (
+	"bytes"
+	"os"
+	"time"
+)
+
+// 一个 chunk 的写入状态, 分区时每个分区有一个
+type chunkState struct {
+	partition          int             // 所属的分区
+	chunkBuffer        *bytes.Buffer   // chunk缓冲区
+	chunkStartValueSn  int64           // chunk 的第一个 value 的 sn
+	chunkEndValueSn    int64           // chunk 的最后一个 value 的 sn
+	chunkValueNum      int             // chunk 中的 value 数量
+	chunkStartOffset   int64           // chunk 的第一个 value 在 rd 中的起始偏移
+	chunkEndOffset     int64           // chunk 的最后一个 value 在 rd 中的结束偏移
+	chunkStartTime     time.Time       // chunk 的第一个 value 写入的时间
+	chunkChecksum      uint32          // 增量计算的 chunk 数据 crc32 校验和, 不包含末尾的分隔符
+	chunkValueEnds     []int           // 开启 includeValues 时记录 chunk 中每个 value 在 chunkBuffer 中的结束位置, JSON 数组格式时为在 chunkRawValues 中的结束位置
+	chunkRawValues     []byte          // JSON 数组格式开启 includeValues 时依次记录 chunk 中每个编码前的 value
+	chunkDelims        []byte          // 开启 includeValues 且设置 delimMatch 时依次记录 chunk 中每个 value 结束的分隔符
+	chunkDelimEnds     []int           // 每个 value 的分隔符在 chunkDelims 中的结束位置
+	chunkSizeExceeded  bool            // chunk 长度是否因为 minChunkValueCount 超过了 chunkSizeLimit
+	chunkEndsWithDelim bool            // chunk 中最后一个 value 在 rd 中是否以分隔符结尾
+	stream             *chunkStream    // 流式 flush 时正在写入的 chunk
+	spillFile          *os.File        // chunk 数据超过 spillThreshold 后写入的临时文件
+	spillN             int             // 已写入 spillFile 的字节数
+	pendingChunk       *FlushChunkArgs // 开启 minLastChunkSize 时延迟 flush 的 chunk
+}
+
+func newChunkState(partition int, chunkSizeLimit int) *chunkState {
+	return &chunkState{
+		partition:   partition,
+		chunkBuffer: bytes.NewBuffer(make([]byte, 0, max(chunkSizeLimit, 0))),
+	}
+}
+
+func (c *chunkState) reset() {
+	c.chunkBuffer.Reset()
+	c.chunkStartValueSn = 0
+	c.chunkEndValueSn = 0
+	c.chunkValueNum = 0
+	c.chunkStartOffset = 0
+	c.chunkEndOffset = 0
+	c.chunkChecksum = 0
+	c.chunkValueEnds = c.chunkValueEnds[:0]
+	c.chunkRawValues = c.chunkRawValues[:0]
+	c.chunkDelims = c.chunkDelims[:0]
+	c.chunkDelimEnds = c.chunkDelimEnds[:0]
+	c.chunkSizeExceeded = false
+	c.chunkEndsWithDelim = false
+	c.stream = nil
+	c.spillFile = nil
+	c.spillN = 0
+	c.pendingChunk = nil
+}
+
+// 切换到 key 对应的分区, 之后写入的 value 和 flush 都作用于这个分区的 chunk
+func (s *splitter) usePartition(key int) {
+	n := len(s.partitions)
+	s.chunkState = s.partitions[(key%n+n)%n]
+}
+
+// 依次切换到每个分区执行 fn, 不分区时只对当前 chunk 执行. 出错时立即返回
+func (s *splitter) eachPartition(fn func() error) error {
+	if s.partitions == nil {
+		return fn()
+	}
+	for _, c := range s.partitions {
+		s.chunkState = c
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 所有非空 chunk 中最早的第一个 value 写入的时间
+func (s *splitter) oldestChunkStartTime() time.Time {
+	if s.partitions == nil {
+		return s.chunkStartTime
+	}
+	var t time.Time
+	for _, c := range s.partitions {
+		if c.chunkValueNum > 0 && (t.IsZero() || c.chunkStartTime.Before(t)) {
+			t = c.chunkStartTime
+		}
+	}
+	return t
+}
+
+// 空闲 flush 后剩余的分区 chunk 需要因为达到最大间隔而 flush 的时间, 为零值表示不需要
+func (s *splitter) partitionFlushDeadline() time.Time {
+	if s.partitions == nil || s.maxChunkInterval <= 0 {
+		return time.Time{}
+	}
+	t := s.oldestChunkStartTime()
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(s.maxChunkInterval)
+}
+
+// 重置所有 chunk 的状态
+func (s *splitter) resetChunkStates() {
+	if s.partitions == nil {
+		s.chunkState.reset()
+		return
+	}
+	for _, c := range s.partitions {
+		c.reset()
+	}
+	s.chunkState = s.partitions[0]
+}
@@ -0,0 +1,104 @@
+package splitter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 等待运行结束, 超时说明在暂停中没有被唤醒
+func waitRunErr(t *testing.T, errCh <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("run not returned while paused")
+		return nil
+	}
+}
+
+func TestPauseThenStopBeforeRun(t *testing.T) {
+	cases := []struct {
+		name string
+		stop func(s Splitter)
+	}{
+		{"pause stop", func(s Splitter) { s.Pause(); s.Stop() }},
+		{"stop pause", func(s Splitter) { s.Stop(); s.Pause() }},
+		{"pause stop and flush", func(s Splitter) { s.Pause(); s.StopAndFlush() }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var chunks int
+			s := NewSplitter(Conf{
+				Delim: []byte(","),
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					chunks++
+					return nil
+				},
+			})
+			c.stop(s)
+			err := waitRunErr(t, s.RunSplitAsync(strings.NewReader("a,b")))
+			if !errors.Is(err, ErrSplitterIsStopped) {
+				t.Fatalf("got %v, want ErrSplitterIsStopped", err)
+			}
+			if chunks != 0 {
+				t.Fatalf("got %d chunks, want none", chunks)
+			}
+
+			// Reset 后不再处于停止状态
+			if err := s.Reset(); err != nil {
+				t.Fatal(err)
+			}
+			if err := waitRunErr(t, s.RunSplitAsync(strings.NewReader("a,b"))); err != nil {
+				t.Fatal(err)
+			}
+			if chunks != 1 {
+				t.Fatalf("after Reset: got %d chunks, want 1", chunks)
+			}
+		})
+	}
+}
+
+func TestPauseThenStopDuringRun(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		var chunks []string
+		var stopped bool
+		paused := make(chan struct{})
+		var s Splitter
+		s = NewSplitter(Conf{
+			Delim:                []byte(","),
+			ChunkSizeLimit:       1024,
+			ChunkValueCountLimit: 2,
+			FlushChunkHandler: func(args *FlushChunkArgs) error {
+				chunks = append(chunks, string(args.ChunkData))
+				stopped = args.IsStopped
+				if len(chunks) == 1 {
+					s.Pause()
+					close(paused)
+				}
+				return nil
+			},
+		})
+		errCh := s.RunSplitAsync(strings.NewReader("a,b,c,d,e"))
+		<-paused
+		time.Sleep(10 * time.Millisecond)
+		if flush {
+			s.StopAndFlush()
+		} else {
+			s.Stop()
+		}
+		if err := waitRunErr(t, errCh); !errors.Is(err, ErrSplitterIsStopped) {
+			t.Fatalf("flush %v: got %v, want ErrSplitterIsStopped", flush, err)
+		}
+		// 读取到 c 时 flush 了第一个 chunk, 暂停时 c 还在缓冲区中, 只有 StopAndFlush 会 flush 它
+		want := []string{"a,b"}
+		if flush {
+			want = append(want, "c")
+		}
+		if strings.Join(chunks, "|") != strings.Join(want, "|") || stopped != flush {
+			t.Fatalf("flush %v: got chunks %q, IsStopped %v", flush, chunks, stopped)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package splitter
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// 每个 chunk 只有一个 value
+func checkPerValueChunks(t *testing.T, chunks []*FlushChunkArgs) {
+	t.Helper()
+	for _, args := range chunks {
+		if args.ValueCount != 1 || args.StartValueSn != args.EndValueSn {
+			t.Errorf("chunk %d: ValueCount = %d, sn %d-%d", args.ChunkSn, args.ValueCount, args.StartValueSn, args.EndValueSn)
+		}
+	}
+}
+
+func TestPerValueChunksLastChunk(t *testing.T) {
+	small := []string{"a", "b", "c"}
+	large := []string{strings.Repeat("x", MinChunkSizeLimit*2), strings.Repeat("y", MinChunkSizeLimit+1), strings.Repeat("z", MinChunkSizeLimit*3)}
+	cases := []struct {
+		name          string
+		values        []string
+		trailingDelim bool
+		maxValueCount int64
+		want          int
+	}{
+		{"small", small, false, 0, 3},
+		{"small trailing delim", small, true, 0, 3},
+		{"large", large, false, 0, 3},
+		{"large trailing delim", large, true, 0, 3},
+		{"small max value count", small, false, 2, 2},
+		{"large max value count", large, true, 2, 2},
+		{"single", small[:1], false, 0, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			input := strings.Join(c.values, ",")
+			if c.trailingDelim {
+				input += ","
+			}
+			chunks := mustCollectChunks(t, Conf{Delim: []byte(","), ChunkSizeLimit: MinChunkSizeLimit, MaxValueCount: c.maxValueCount, PerValueChunks: true}, input)
+			checkPerValueChunks(t, chunks)
+			if len(chunks) != c.want {
+				t.Fatalf("got %d chunks, want %d", len(chunks), c.want)
+			}
+			for i, chunk := range chunks {
+				if string(chunk.ChunkData) != c.values[i] {
+					t.Errorf("chunk %d = %q, want %q", i, chunk.ChunkData, c.values[i])
+				}
+				if isLast := i == len(chunks)-1; chunk.IsLastChunk != isLast {
+					t.Errorf("chunk %d: IsLastChunk = %v, want %v", i, chunk.IsLastChunk, isLast)
+				}
+			}
+		})
+	}
+}
+
+func TestPerValueChunksStop(t *testing.T) {
+	var s Splitter
+	conf := Conf{
+		Delim:          []byte(","),
+		PerValueChunks: true,
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			if args.ChunkSn == 0 {
+				s.Stop()
+			}
+			return nil
+		},
+	}
+	chunks, err := collectChunksWith(conf, func(sp Splitter) error {
+		s = sp
+		return s.RunSplit(strings.NewReader("a,b,c,d"))
+	})
+	if !errors.Is(err, ErrSplitterIsStopped) {
+		t.Fatalf("got %v, want ErrSplitterIsStopped", err)
+	}
+	checkPerValueChunks(t, chunks)
+	// 停止前已读取的 value 仍然会 flush, 但不是最后一个 chunk
+	want := []string{"a", "b"}
+	if got := chunkStrings(chunks); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for _, args := range chunks {
+		if args.IsLastChunk || args.FlushReason != FlushReasonValueLimit {
+			t.Errorf("chunk %d: IsLastChunk %v, FlushReason %v", args.ChunkSn, args.IsLastChunk, args.FlushReason)
+		}
+	}
+}
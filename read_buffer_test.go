@@ -0,0 +1,65 @@
+package splitter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// 记录 Read 的调用次数
+type countingReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
+// 分隔 input 并返回从 rd 读取的次数
+func countReads(tb testing.TB, input string, readBufferSize int) int {
+	tb.Helper()
+	cr := &countingReader{r: strings.NewReader(input)}
+	err := NewSplitter(Conf{
+		Delim:             []byte("\n"),
+		ChunkSizeLimit:    64 << 10,
+		ReadBufferSize:    readBufferSize,
+		FlushChunkHandler: func(args *FlushChunkArgs) error { return nil },
+	}).RunSplit(cr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cr.reads
+}
+
+func TestReadBufferSizeReducesReads(t *testing.T) {
+	input := numberedInput(50000)
+	prev := 0
+	for _, size := range []int{DefaultReadBufferSize, 64 << 10, 1 << 20} {
+		reads := countReads(t, input, size)
+		// 每次 Read 都会填满缓冲区, 最后还有一次返回 io.EOF 的读取
+		if want := len(input)/size + 2; reads > want {
+			t.Errorf("ReadBufferSize %d: %d reads, want at most %d", size, reads, want)
+		}
+		if prev > 0 && reads >= prev {
+			t.Errorf("ReadBufferSize %d: %d reads, not fewer than %d with smaller buffer", size, reads, prev)
+		}
+		prev = reads
+	}
+}
+
+func BenchmarkReadBufferSize(b *testing.B) {
+	input := numberedInput(100000)
+	for _, size := range []int{DefaultReadBufferSize, 64 << 10, 1 << 20} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			var reads int
+			for range b.N {
+				reads = countReads(b, input, size)
+			}
+			b.ReportMetric(float64(reads), "reads/op")
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package splitter
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResetDuringFinishCallback(t *testing.T) {
+	var resetErr error
+	var s Splitter
+	s = NewSplitter(Conf{
+		Delim:             []byte("\n"),
+		FlushChunkHandler: func(args *FlushChunkArgs) error { return nil },
+		OnFinish: func(err error, totalChunks int, totalValues int64) {
+			resetErr = s.Reset()
+		},
+	})
+	done := s.Done()
+	if err := s.RunSplit(strings.NewReader("a\nb\nc")); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(resetErr, ErrSplitterIsRunning) {
+		t.Fatalf("Reset in OnFinish: got %v, want ErrSplitterIsRunning", resetErr)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("Done not closed after RunSplit returned")
+	}
+	if s.Done() != done {
+		t.Fatal("Done channel replaced by Reset in OnFinish")
+	}
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset after run: %v", err)
+	}
+}
+
+func TestResetWhileFinishCallbackBlocked(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	s := NewSplitter(Conf{
+		Delim:             []byte("\n"),
+		FlushChunkHandler: func(args *FlushChunkArgs) error { return nil },
+		OnFinish: func(err error, totalChunks int, totalValues int64) {
+			once.Do(func() {
+				close(entered)
+				<-release
+			})
+		},
+	})
+	done := s.Done()
+	runErr := make(chan error, 1)
+	go func() { runErr <- s.RunSplit(strings.NewReader("a\nb")) }()
+
+	<-entered
+	// 此时 stats 已可读取, 但回调尚未返回
+	if s.Stats().ValueNum != 2 {
+		t.Fatalf("ValueNum = %d, want 2", s.Stats().ValueNum)
+	}
+	if err := s.Reset(); !errors.Is(err, ErrSplitterIsRunning) {
+		t.Fatalf("Reset while OnFinish blocked: got %v, want ErrSplitterIsRunning", err)
+	}
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done not closed")
+	}
+	if err := <-runErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset after run: %v", err)
+	}
+	if err := s.RunSplit(strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 比较 Reset 复用和每次新建 splitter 处理多个输入
+func BenchmarkSplitReuse(b *testing.B) {
+	input := numberedInput(1000)
+	conf := Conf{Delim: []byte("\n"), ChunkSizeLimit: 1 << 20, FlushChunkHandler: func(args *FlushChunkArgs) error { return nil }}
+	b.Run("Reset", func(b *testing.B) {
+		s := NewSplitter(conf)
+		b.ReportAllocs()
+		for range b.N {
+			if err := s.Reset(); err != nil {
+				b.Fatal(err)
+			}
+			if err := s.RunSplit(strings.NewReader(input)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("NewSplitter", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if err := NewSplitter(conf).RunSplit(strings.NewReader(input)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package splitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrFlushRetryExhausted = errors.New("flush chunk retry exhausted")
+
+// FlushChunkHandler 返回错误时的重试配置, 零值表示不重试
+type FlushRetry struct {
+	MaxAttempts    int                  // 最多调用 FlushChunkHandler 的次数(包括第一次), <=1 表示不重试
+	InitialBackoff time.Duration        // 第一次重试前的等待时间, 之后每次翻倍, <=0 时使用 DefaultFlushRetryBackoff
+	MaxBackoff     time.Duration        // 最长的等待时间, <=0 表示不限制
+	IsRetryable    func(err error) bool // 判断错误是否可以重试, 为 nil 时除了 panic 以外的错误都会重试
+}
+
+// 错误是否可以重试, panic 总是不重试
+func (r *FlushRetry) isRetryable(err error) bool {
+	if errors.Is(err, ErrHandlerPanic) {
+		return false
+	}
+	return r.IsRetryable == nil || r.IsRetryable(err)
+}
+
+// 调用 flushChunkHandler, 设置了 flushRetry 时会使用同一个 args 重试可以重试的错误.
+// 重试次数用完时返回同时包装了 ErrFlushRetryExhausted 和最后一个错误的错误
+func (s *splitter) flushWithRetry(args *FlushChunkArgs) error {
+	err := s.callFlushChunkHandler(args)
+	r := &s.flushRetry
+	if err == nil || r.MaxAttempts <= 1 {
+		return err
+	}
+
+	backoff := r.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultFlushRetryBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		if !r.isRetryable(err) {
+			return err
+		}
+		if attempt >= r.MaxAttempts {
+			return fmt.Errorf("%w: chunkSn=%d, attempts=%d: %w", ErrFlushRetryExhausted, args.ChunkSn, attempt, err)
+		}
+		if wErr := s.waitRetry(args, backoff); wErr != nil {
+			return wErr
+		}
+		if sErr := args.rewindSpill(); sErr != nil {
+			return fmt.Errorf("%w: chunkSn=%d: %w", ErrChunkSpill, args.ChunkSn, sErr)
+		}
+		backoff *= 2
+		if r.MaxBackoff > 0 {
+			backoff = min(backoff, r.MaxBackoff)
+		}
+		if err = s.callFlushChunkHandler(args); err == nil {
+			return nil
+		}
+	}
+}
+
+// 等待下一次重试, 停止或取消时返回 ctx 的 cause. StopAndFlush 时 ctx 已被取消, 此时需要保证剩余数据被处理, 所以会等待完整的时间
+func (s *splitter) waitRetry(args *FlushChunkArgs, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	if args.IsStopped {
+		<-t.C
+		return nil
+	}
+	select {
+	case <-t.C:
+		return nil
+	case <-s.ctx.Done():
+		return context.Cause(s.ctx)
+	}
+}
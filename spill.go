@@ -0,0 +1,128 @@
+package splitter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+var ErrChunkSpill = errors.New("spill chunk to file failed")
+
+const (
+	spillOwned int32 = iota // 由 splitter 负责关闭和删除
+	spillTaken              // 已通过 TakeSpillFile 交给调用者
+	spillDone               // 已关闭和删除
+)
+
+// 获取 spill 文件的所有权, 之后 splitter 不会再关闭 SpillReader 和删除 SpillPath, 需要调用者负责. 返回 SpillPath, 没有 spill 时返回空字符串.
+// 不调用时 handler(开启 OrderedFlush 时为 OrderedFlushHandler) 返回后文件会被删除
+func (a *FlushChunkArgs) TakeSpillFile() string {
+	if a.spillFile == nil {
+		return ""
+	}
+	atomic.CompareAndSwapInt32(&a.spillState, spillOwned, spillTaken)
+	return a.SpillPath
+}
+
+// 关闭并删除没有被取走的 spill 文件
+func (a *FlushChunkArgs) releaseSpill() {
+	if a.spillFile == nil || !atomic.CompareAndSwapInt32(&a.spillState, spillOwned, spillDone) {
+		return
+	}
+	_ = a.spillFile.Close()
+	_ = os.Remove(a.SpillPath)
+}
+
+// 重试 handler 前将 SpillReader 移回文件开头
+func (a *FlushChunkArgs) rewindSpill() error {
+	if a.spillFile == nil {
+		return nil
+	}
+	_, err := a.spillFile.Seek(0, io.SeekStart)
+	return err
+}
+
+// 返回读取 chunk 数据的 reader 和数据长度, spill 时从 SpillReader 读取
+func (a *FlushChunkArgs) chunkReader() (io.Reader, int64) {
+	if a.SpillReader != nil {
+		return a.SpillReader, a.spillSize
+	}
+	return bytes.NewReader(a.ChunkData), int64(len(a.ChunkData))
+}
+
+// flush 时是否需要将 chunk 数据写入临时文件, 写入 chunk 时已经开始 spill 的 chunk 总是需要
+func (s *splitter) needSpill(data []byte) bool {
+	return s.spillThreshold > 0 && (s.spillFile != nil || int64(len(data)) > s.spillThreshold)
+}
+
+// 写入 value 后检查 chunk 数据是否超过 spillThreshold, 超过时将缓冲区中除末尾分隔符外的数据写入临时文件,
+// 之后写入的 value 也会追加到这个文件, 所以超大的 chunk 不会完整保存在内存中
+func (s *splitter) spillChunkBuffer() error {
+	data := s.chunkBuffer.Bytes()
+	data = data[:len(data)-len(s.outputDelim)]
+	if s.spillFile == nil && int64(len(data)) <= s.spillThreshold {
+		return nil
+	}
+	if err := s.writeSpill(data); err != nil {
+		return fmt.Errorf("%w: chunkSn=%d: %w", ErrChunkSpill, s.chunkSn, err)
+	}
+	return nil
+}
+
+// 将 data 追加到当前 chunk 的临时文件并从 chunk 缓冲区中丢弃, 没有文件时在 spillDir 中创建
+func (s *splitter) writeSpill(data []byte) error {
+	if s.spillFile == nil {
+		f, err := os.CreateTemp(s.spillDir, "splitter-chunk-*")
+		if err != nil {
+			return err
+		}
+		s.spillFile = f
+	}
+	n, err := s.spillFile.Write(data)
+	s.spillN += n
+	s.chunkBuffer.Next(len(data))
+	return err
+}
+
+// flush 时将剩余的 chunk 数据 data 写入临时文件并交给 args, 成功后 SpillReader 位于文件开头. 返回 chunk 数据的总长度
+func (s *splitter) spillChunk(args *FlushChunkArgs, data []byte) (int, error) {
+	err := s.writeSpill(data)
+	f, n := s.spillFile, s.spillN
+	s.spillFile, s.spillN = nil, 0
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		if f != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+		return 0, fmt.Errorf("%w: chunkSn=%d: %w", ErrChunkSpill, args.ChunkSn, err)
+	}
+
+	args.SpillPath = f.Name()
+	args.SpillReader = f
+	args.spillFile = f
+	args.spillSize = int64(n)
+	return n, nil
+}
+
+// 运行结束时删除还没有 flush 的 chunk 的临时文件
+func (s *splitter) discardSpill() error {
+	if s.spillFile != nil {
+		_ = s.spillFile.Close()
+		_ = os.Remove(s.spillFile.Name())
+		s.spillFile, s.spillN = nil, 0
+	}
+	return nil
+}
+
+// spill 后丢弃因为超大 chunk 而扩容的缓冲区, 避免一直占用内存
+func (s *splitter) shrinkChunkBuffer() {
+	if int64(s.chunkBuffer.Cap()) > s.spillThreshold {
+		s.chunkBuffer = bytes.NewBuffer(make([]byte, 0, s.chunkSizeLimit))
+	}
+}
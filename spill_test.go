@@ -0,0 +1,210 @@
+package splitter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 检查 dir 中没有残留的 spill 文件
+func checkSpillDirEmpty(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("spill dir has %d leftover files", len(entries))
+	}
+}
+
+func TestSpillThresholdCrossover(t *testing.T) {
+	const threshold = 32
+	for _, size := range []int{threshold - 1, threshold, threshold + 1} {
+		dir := t.TempDir()
+		// 两个 value 组成一个长度为 size 的 chunk
+		input := strings.Repeat("a", size/2) + "\n" + strings.Repeat("b", size-size/2-1)
+		var data []byte
+		var spilled bool
+		conf := Conf{
+			Delim:          []byte("\n"),
+			ChunkSizeLimit: 1024,
+			SpillThreshold: threshold,
+			SpillDir:       dir,
+			FlushChunkHandler: func(args *FlushChunkArgs) error {
+				spilled = args.SpillPath != ""
+				if !spilled {
+					data = args.ChunkData
+					return nil
+				}
+				if args.ChunkData != nil {
+					t.Errorf("size %d: ChunkData = %q, want nil", size, args.ChunkData)
+				}
+				var err error
+				data, err = io.ReadAll(args.SpillReader)
+				return err
+			},
+		}
+		s := NewSplitter(conf)
+		if err := s.RunSplit(strings.NewReader(input)); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if want := size > threshold; spilled != want {
+			t.Errorf("size %d: spilled = %v, want %v", size, spilled, want)
+		}
+		if string(data) != input {
+			t.Errorf("size %d: data = %q, want %q", size, data, input)
+		}
+		if got := s.Stats().ChunkByteNum; got != int64(size) {
+			t.Errorf("size %d: ChunkByteNum = %d, want %d", size, got, size)
+		}
+		checkSpillDirEmpty(t, dir)
+	}
+}
+
+func TestSpillIncremental(t *testing.T) {
+	const threshold = 64
+	dir := t.TempDir()
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		sb.WriteString(strings.Repeat(string(rune('a'+i%26)), i%10+1))
+		sb.WriteString("\n")
+	}
+	input := sb.String()
+
+	var s *splitter
+	maxBuffered := 0
+	var chunks []string
+	s = NewSplitter(Conf{
+		Delim:          []byte("\n"),
+		ChunkSizeLimit: 1 << 20,
+		SpillThreshold: threshold,
+		SpillDir:       dir,
+		ValueHandler: func(sn int64, startOffset int64, value []byte) {
+			maxBuffered = max(maxBuffered, s.chunkBuffer.Len())
+		},
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			data, err := io.ReadAll(args.SpillReader)
+			chunks = append(chunks, string(data))
+			return err
+		},
+	}).(*splitter)
+	if err := s.RunSplit(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过阈值后缓冲区只保留最后一个 value 和分隔符
+	if maxBuffered > threshold+10+1 {
+		t.Errorf("chunk buffer held %d bytes, want at most %d", maxBuffered, threshold+11)
+	}
+	if len(chunks) != 1 || chunks[0] != strings.TrimSuffix(input, "\n") {
+		t.Errorf("chunks = %q", chunks)
+	}
+	checkSpillDirEmpty(t, dir)
+}
+
+func TestSpillBuiltinWriters(t *testing.T) {
+	input := "aaaaaaaaaaaaaaaaaaaa\nbb\ncc\nddddddddddddddddddddddddddddddd\nee\n"
+	conf := Conf{Delim: []byte("\n"), ChunkSizeLimit: 20, SpillThreshold: 16}
+
+	t.Run("NewWriterSplitter", func(t *testing.T) {
+		var buf bytes.Buffer
+		conf := conf
+		conf.SpillDir = t.TempDir()
+		if err := NewWriterSplitter(conf, &buf, []byte("\n")).RunSplit(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != input {
+			t.Errorf("got %q, want %q", buf.String(), input)
+		}
+		checkSpillDirEmpty(t, conf.SpillDir)
+	})
+
+	t.Run("DefaultHandlerWriter", func(t *testing.T) {
+		var buf bytes.Buffer
+		conf := conf
+		conf.SpillDir = t.TempDir()
+		conf.DefaultHandlerWriter = &buf
+		if err := NewSplitter(conf).RunSplit(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != input {
+			t.Errorf("got %q, want %q", buf.String(), input)
+		}
+	})
+
+	t.Run("NewFramedChunkWriter", func(t *testing.T) {
+		var buf bytes.Buffer
+		conf := conf
+		conf.SpillDir = t.TempDir()
+		conf.FlushChunkHandler = NewFramedChunkWriter(&buf)
+		if err := NewSplitter(conf).RunSplit(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		fr := NewFramedChunkReader(&buf, 0)
+		for {
+			data, err := fr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(data))
+		}
+		if strings.Join(got, "\n")+"\n" != input {
+			t.Errorf("got %q", got)
+		}
+	})
+
+	t.Run("CollectChunks", func(t *testing.T) {
+		conf := conf
+		conf.SpillDir = t.TempDir()
+		chunks, err := CollectChunks(strings.NewReader(input), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range chunks {
+			if c.SpillPath != "" {
+				t.Errorf("chunk %d was spilled", c.ChunkSn)
+			}
+			got = append(got, string(c.ChunkData))
+		}
+		if strings.Join(got, "\n")+"\n" != input {
+			t.Errorf("got %q", got)
+		}
+	})
+}
+
+func TestSpillRemovedOnStop(t *testing.T) {
+	dir := t.TempDir()
+	pr, pw := io.Pipe()
+	s := NewSplitter(Conf{Delim: []byte("\n"), ChunkSizeLimit: 1 << 20, SpillThreshold: 8, SpillDir: dir})
+	errCh := s.RunSplitAsync(pr)
+	_, _ = pw.Write([]byte("0123456789\nabcdefghij\n"))
+
+	// 等待数据写入临时文件
+	deadline := time.Now().Add(time.Second)
+	for {
+		entries, _ := os.ReadDir(dir)
+		if len(entries) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("chunk was not spilled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Stop()
+	if err := <-errCh; err != ErrSplitterIsStopped {
+		t.Fatalf("got %v, want ErrSplitterIsStopped", err)
+	}
+	checkSpillDirEmpty(t, dir)
+	_ = pw.Close()
+}
@@ -0,0 +1,71 @@
+package splitter
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// 返回按 delim 切分的 bufio.SplitFunc, 可以用于 bufio.Scanner, 切分结果和 ValueReader 一致. delim 为空时 panic
+func SplitFunc(delim []byte) bufio.SplitFunc {
+	return ConfSplitFunc(Conf{Delim: delim})
+}
+
+// 返回按 conf 中的 Delim, DelimMatch, Quote 和 Escape 切分的 bufio.SplitFunc, 其他配置不会生效.
+// 和 bufio.ScanLines 一样会返回空的 token. conf.Delim 为空时 panic
+func ConfSplitFunc(conf Conf) bufio.SplitFunc {
+	if len(conf.Delim) == 0 {
+		panic(ErrEmptyDelim)
+	}
+	v := &valueReader{delim: conf.Delim, delimMatch: conf.DelimMatch}
+	if v.delimMatch == nil {
+		v.quote = conf.Quote
+		v.escape = conf.Escape
+	}
+	return v.split
+}
+
+// 实现 bufio.SplitFunc, 每次从 data 开头重新扫描, 不会修改 v 的状态, 所以可以被多个 Scanner 同时使用
+func (v *valueReader) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if v.delimMatch != nil {
+		for i := range data {
+			m := v.delimMatch(data[i:])
+			if m <= 0 {
+				continue
+			}
+			m = min(m, len(data)-i)
+			if i+m == len(data) && !atEOF {
+				return 0, nil, nil // 分隔符可能延续到还没有读取的数据中
+			}
+			return i + m, data[:i], nil
+		}
+	} else if v.quote == 0 && v.escape == 0 {
+		if i := bytes.Index(data, v.delim); i >= 0 {
+			return i + len(v.delim), data[:i], nil
+		}
+	} else {
+		delimLen := len(v.delim)
+		last := v.delim[delimLen-1]
+		var st scanState
+		for pos := 0; pos < len(data); {
+			n, _ := v.scanSpecial(st, data[pos:], last)
+			_, st = v.scanSpecial(st, data[pos:pos+n], last)
+			pos += n
+			if !st.inQuote && st.afterLiteral != delimLen && data[pos-1] == last && pos >= delimLen && bytes.Equal(data[pos-delimLen:pos], v.delim) {
+				return pos, v.unescape(data[:pos-delimLen]), nil
+			}
+		}
+		if atEOF && st.inQuote {
+			return 0, nil, ErrValueReaderUnterminatedQuote
+		}
+	}
+
+	// 没有找到分隔符, 读取到 EOF 时剩余的数据作为最后一个 token
+	if !atEOF {
+		return 0, nil, nil
+	}
+	return len(data), v.unescape(data), nil
+}
@@ -2,172 +2,1496 @@ package splitter
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"iter"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var ErrSplitterIsStarted = errors.New("splitter is started")
 var ErrSplitterIsStopped = errors.New("splitter is stopped")
+var ErrSplitterIsCanceled = errors.New("splitter is canceled")
+var ErrSplitterIsRunning = errors.New("splitter is running")
+var ErrSplitTimeout = errors.New("split timeout")
+var ErrQuoteInDelim = errors.New("quote must not be in delim")
+var ErrInvalidEscape = errors.New("escape must not be in delim or equal to quote")
+var ErrInvalidCompressLevel = errors.New("invalid compress level")
+var ErrValueFilter = errors.New("value filter err")
+
+// 运行超时错误, errors.Is(err, ErrSplitTimeout) 为 true
+type SplitTimeoutError struct {
+	ScanByteNum int64 // 超时前已完整处理的 value 扫描rd的字节数
+	ChunkNum    int   // 超时前已 flush 的 chunk 数
+}
+
+func (e *SplitTimeoutError) Error() string {
+	return fmt.Sprintf("split timeout, scanByteNum=%d, chunkNum=%d", e.ScanByteNum, e.ChunkNum)
+}
+
+func (e *SplitTimeoutError) Is(target error) bool {
+	return target == ErrSplitTimeout
+}
+
+// TrimSpace 时去掉的字符
+const asciiSpace = " \t\n\v\f\r"
 
 const (
 	MinChunkSizeLimit        = 16
 	MinValueMaxScanSizeLimit = 4096
+	MinReadBufferSize        = 16
+
+	DefaultReadBufferSize     = 4096
+	DefaultFollowPollInterval = time.Second
+	DefaultProgressInterval   = 1 << 20
+	DefaultMaxReadRetries     = 5
+	DefaultFlushRetryBackoff  = 100 * time.Millisecond
 )
 
 type FlushChunkArgs struct {
-	ChunkSn      int    // chunk sn
-	StartValueSn int64  // 第一个 value 的 sn
-	EndValueSn   int64  // 最后一个 value 的 sn
-	ChunkData    []byte // chunk数据
-	ScanByteNum  int64  // 已扫描rd的字节数
+	ChunkSn          int         // chunk sn, 分区时在所有分区中唯一
+	ChunkID          string      // chunk ID, 由 ChunkIDFunc 生成, 默认为 ChunkSn 的十进制字符串
+	Partition        int         // chunk 所属的分区, 不分区时为 0
+	StartValueSn     int64       // 第一个 value 的 sn
+	EndValueSn       int64       // 最后一个 value 的 sn
+	ValueCount       int         // chunk 中的 value 数
+	ChunkData        []byte      // chunk数据
+	ScanByteNum      int64       // 已扫描rd的字节数
+	StartOffset      int64       // chunk 中第一个 value 在 rd 中的起始偏移
+	EndOffset        int64       // chunk 中最后一个 value 及其分隔符在 rd 中的结束偏移(不包含), [StartOffset, EndOffset) 包含了被过滤的 value 和分隔符
+	IsLastChunk      bool        // 是否为最后一个 chunk, 仅在读取到 EOF, StopAndFlush 或者达到 MaxValueCount 时 flush 的 chunk 为 true
+	IsStopped        bool        // 是否为调用 StopAndFlush 后 flush 的剩余数据
+	FlushReason      FlushReason // chunk 被 flush 的原因
+	Checksum         uint32      // ChunkData 的校验和, 开启 CompressChunks 时为压缩前数据的校验和, 未开启校验和时为 0
+	UncompressedSize int         // 开启 CompressChunks 时为压缩前 ChunkData 的长度, 否则为 0
+	RawSize          int         // 开启 EncodeChunkBase64 时为编码前 ChunkData 的长度(开启 CompressChunks 时为压缩后的长度), 否则为 0
+	SizeExceeded     bool        // chunk 长度是否因为 MinChunkValueCount 超过了 ChunkSizeLimit
+	DelimSuffix      bool        // ChunkData 是否以分隔符结尾, 仅在开启 KeepTrailingDelim 时可能为 true. 为 false 的最后一个 chunk 表示 rd 不以分隔符结尾
+	Values           [][]byte    // chunk 中的每个 value(过滤后), 仅在开启 IncludeValues 时提供, 和 ChunkData 一样可以安全持有
+	ValueDelims      [][]byte    // 开启 IncludeValues 且设置 DelimMatch 时为 Values 中每个 value 在 rd 中结束的分隔符, 没有以分隔符结束的 value 为 nil
+
+	SpillPath   string        // chunk 数据超过 SpillThreshold 时写入的临时文件路径, 此时 ChunkData 为 nil
+	SpillReader io.ReadCloser // 读取 SpillPath 的数据, 从文件开头开始. 调用 TakeSpillFile 后需要调用者关闭
+
+	pooledData *[]byte // 开启 PoolChunkData 时 ChunkData 使用的缓冲区
+	released   int32   // 是否已调用 Release
+	skipped    bool    // 是否被 ChunkFilter 跳过, 仅用于按顺序 flush 时占用 ChunkSn
+	pendingLen int64   // 提交到并发 flush 工作池时计入积压的数据长度, handler 可能修改 ChunkData, 所以提前记录
+	spillFile  *os.File
+	spillSize  int64 // spill 文件的长度
+	spillState int32 // spill 文件的所有权状态
 }
 
-// flush Chunk 函数
-type FlushChunkHandler func(args *FlushChunkArgs)
+// flush Chunk 函数, 返回错误时会停止分隔并由 RunSplit 返回这个错误
+type FlushChunkHandler func(args *FlushChunkArgs) error
+
+// 带 ctx 的 flush Chunk 函数, ctx 为运行使用的 ctx, 会在 Stop, 运行超时或者 RunSplitContext 传入的 ctx 被取消时取消
+type FlushChunkCtxHandler func(ctx context.Context, args *FlushChunkArgs) error
+
+// 流式 flush Chunk 函数, chunk 的数据不会被完整缓冲, 而是在读取 value 时写入 r, 在 chunk 的第一个 value 写入时被调用.
+// 此时 args.ChunkData 为 nil, 只有 ChunkSn, StartValueSn 和 StartOffset 是有效的, 其他字段会在 r 返回 io.EOF 前设置.
+// 返回时如果没有读取完 r, 剩余的数据会被丢弃. 返回错误时会停止分隔并由 RunSplit 返回这个错误
+type FlushChunkStreamHandler func(args *FlushChunkArgs, r io.Reader) error
+
+// flush 策略, 任意一个设置的限制将被超过时都会 flush 当前 chunk, 为零值的字段表示使用 Conf 中对应的配置
+type FlushPolicy struct {
+	MaxBytes  int           // chunk 长度限制, 同 ChunkSizeLimit
+	MaxValues int           // chunk 的 value 数量限制, 同 ChunkValueCountLimit
+	MaxAge    time.Duration // chunk 最大间隔, 同 MaxChunkInterval
+}
+
+// chunk 被 flush 的原因
+type FlushReason int
+
+const (
+	// 加入下一个 value 会超过 chunk 长度限制
+	FlushReasonSizeLimit FlushReason = iota
+	// chunk 的 value 数量达到限制
+	FlushReasonValueLimit
+	// chunk 的第一个 value 写入后超过了最大间隔
+	FlushReasonAgeLimit
+	// 空闲超时
+	FlushReasonIdle
+	// 读取到 EOF
+	FlushReasonEOF
+	// 调用了 StopAndFlush
+	FlushReasonStopped
+	// 写入的 value 数达到 MaxValueCount
+	FlushReasonMaxValueCount
+)
+
+// 出错时的处理方式
+type ErrorAction int
 
-// 值过滤器, 返回空字节或者nil则抛弃该value
+const (
+	// 停止运行并返回错误
+	ErrorActionAbort ErrorAction = iota
+	// 忽略错误继续读取. 读取 rd 出错时会继续读取当前 value, value 超长时已读取的部分会被丢弃, 剩余部分作为下一个 value 读取
+	ErrorActionContinue
+	// 丢弃当前 value 的剩余数据直到下一个分隔符, 然后继续读取下一个 value
+	ErrorActionSkip
+)
+
+// 错误处理函数, 用于处理读取 value 时的错误(不包括停止和取消), scanByteNum 为出错时已扫描rd的字节数
+type ErrorHandler func(err error, scanByteNum int64) ErrorAction
+
+// value 超过最大扫描长度时的回调, partial 为已读取的部分, 仅在回调返回前有效
+type OversizeValueHandler func(partial []byte) error
+
+// 读取出错时的回调, attempt 为读取当前 value 时出错的次数(从 1 开始), 返回 true 表示重试
+type ReadErrorHandler func(err error, attempt int) bool
+
+// 运行结束回调, err 为 RunSplit 返回的错误
+type OnFinishHandler func(err error, totalChunks int, totalValues int64)
+
+// 进度回调, scanByteNum 为已扫描rd的字节数
+type ProgressHandler func(scanByteNum int64)
+
+// 校验和函数
+type ChecksumFunc func(data []byte) uint32
+
+// 值过滤器, 返回空字节或者nil则抛弃该value, 开启 KeepEmptyValues 时仅返回 nil 才会抛弃
 type ValueFilter func(value []byte) []byte
 
+// 带 sn 的值过滤器, sn 为这个 value 保留时会使用的 sn, 返回空字节或者nil则抛弃该value, 开启 KeepEmptyValues 时仅返回 nil 才会抛弃
+type ValueSnFilter func(sn int64, value []byte) []byte
+
+// 可以返回错误的值过滤器, 返回 nil, nil 时抛弃该 value, 返回错误时会停止运行
+type ValueFilterE func(value []byte) ([]byte, error)
+
+// 内部使用的值过滤器, 由 ValueSnFilter, ValueFilterE 或 ValueFilter 转换而来
+type filterFunc func(sn int64, value []byte) ([]byte, error)
+
+// 分区函数, 返回 value 所属的分区, 会对分区数取模
+type PartitionKeyFunc func(value []byte) int
+
+// 生成 chunk 头部或尾部数据的函数, 返回 nil 表示不写入
+type ChunkDecorator func(args *FlushChunkArgs) []byte
+
+// 将 value 写入 chunk 的函数, 每个 value 只调用一次. value 之间的分隔符仍然由分隔器写入, 写入的数据不会被修改.
+// dst 是一个临时缓冲区, 写入的数据会被追加到 chunk 中. index 为调用时 chunk 中已有的 value 数, 因为写入后才能知道长度,
+// 加入这个 value 需要先 flush 当前 chunk 时, 写入的数据会作为下一个 chunk 的第一个 value
+type ChunkJoiner func(dst *bytes.Buffer, value []byte, index int)
+
+// chunk 过滤器, 返回 false 时跳过这个 chunk, 不会调用 FlushChunkHandler
+type ChunkFilter func(args *FlushChunkArgs) bool
+
+// chunk 转换函数, 将转换后的 data 写入 dst, dst 为空的内部缓冲区. data 仅在函数返回前有效, 返回错误时会停止分隔
+type ChunkTransformer func(dst *bytes.Buffer, args *FlushChunkArgs, data []byte) error
+
+// value 回调, 在保留的 value 写入 chunk 前调用, startOffset 为这个 value 在 rd 中的起始偏移. value 仅在回调返回前有效
+type ValueHandler func(sn int64, startOffset int64, value []byte)
+
 type Splitter interface {
 	// 从 io.Reader 中读取数据，按配置进行分片和处理。阻塞等待直到完成或者退出或者出错
 	// 仅允许调用一次，重复调用将返回错误。
 	RunSplit(rd io.Reader) error
-	// 停止
+	// 同 RunSplit, 但是可以通过 ctx 取消, 取消后返回包装了 ctx.Err() 的 ErrSplitterIsCanceled, 且不会再调用 FlushChunkHandler
+	RunSplitContext(ctx context.Context, rd io.Reader) error
+	// 在新的 goroutine 中运行 RunSplit, 完成后向返回的 chan 发送一次结果(成功时为 nil). 重复调用时会立即发送 ErrSplitterIsStarted
+	RunSplitAsync(rd io.Reader) <-chan error
+	// 在新的 goroutine 中运行分隔, chunk 会发送到返回的 chunk chan(缓冲区大小为 bufSize) 而不是调用 FlushChunkHandler.
+	// 运行结束后会关闭 chunk chan, 然后向 error chan 发送一次结果(成功时为 nil)并关闭. 重复调用时会立即发送 ErrSplitterIsStarted
+	RunSplitChan(rd io.Reader, bufSize int) (<-chan *FlushChunkArgs, <-chan error)
+	// 将 ra 的 [0, size) 按分隔符对齐分为 workers 个范围并行分隔, workers <=0 时使用 runtime.NumCPU().
+	// chunk 的 ChunkSn, value sn 和偏移会按范围的顺序重新编号, 和顺序读取时一致. 部分配置不支持, 此时返回 ErrParallelUnsupported
+	RunSplitParallel(ra io.ReaderAt, size int64, workers int) error
+	// 返回一个迭代器, 依次产出经过过滤的 value, 不会构建 chunk. 跳出循环会停止读取. 出错时会产出一次 (nil, err) 后结束.
+	// 产出的 value 仅在下一次迭代前有效. 和 RunSplit 一样仅允许调用一次
+	Values(rd io.Reader) iter.Seq2[[]byte, error]
+	// 停止, 会中断阻塞中的读取, RunSplit 会尽快返回 ErrSplitterIsStopped
 	Stop()
+	// 停止, 并在 RunSplit 返回 ErrSplitterIsStopped 前 flush 已缓冲的数据, 此时 FlushChunkArgs.IsStopped 为 true
+	StopAndFlush()
+	// 暂停, RunSplit 会在读取下一个 value 前阻塞等待, 直到调用 Resume 或者停止/取消. 暂停不会 flush 已缓冲的数据
+	Pause()
+	// 恢复
+	Resume()
+	// 获取运行统计, 在 RunSplit 返回前调用会返回零值
+	Stats() Stats
+	// 获取当前已扫描rd的字节数, 可以在运行中调用, 用于显示进度
+	ScanByteNum() int64
+	// 获取并发 flush 时当前积压(已提交但还没有处理完成)的 chunk 数和数据字节数, 可以在运行中调用. 没有开启 FlushConcurrency 时总是返回 0
+	PendingChunks() (chunkNum int, byteNum int64)
+	// 修改每秒扫描字节数的上限, 爆发量为其十分之一, <=0 表示不限速. 可以在运行中调用, 没有设置 RateLimit 时也会生效. Reset 后仍然使用修改后的值
+	SetRateLimit(rateLimit int)
+	// 返回一个在 RunSplit 返回后(完成/停止/出错)关闭的 chan, 此时最后一次 FlushChunkHandler 已经返回
+	Done() <-chan struct{}
+	// 获取 RunSplit 返回的错误, 在 Done 关闭前调用返回 nil
+	LastError() error
+	// 重置状态, 之后可以再次调用 RunSplit 处理新的输入, chunk 缓冲区会被保留复用. 如果正在运行(包括结束回调执行期间)则返回 ErrSplitterIsRunning.
+	// 不要与 RunSplit 并发调用
+	Reset() error
 }
 
 type Conf struct {
-	Delim                 []byte            // 分隔符
-	ChunkSizeLimit        int               // chunk 长度限制, 一个chunk的长度一般会小于这个值, 但是value超出chunk长度时会作为一个chunk, 此时chunk长度会超出这个值
-	FlushChunkHandler     FlushChunkHandler // flushChunk函数
-	ValueMaxScanSizeLimit int               // value 最大扫描长度限制, 如果扫描一定长度还无法确认一个完整的value则返回错误
-	ValueFilter           ValueFilter       // value过滤器
-	RateLimit             int               // 限速器, 限制每秒扫描字节数
+	Delim                   []byte                  // 分隔符
+	Quote                   byte                    // 引号字符, 设置后引号内的分隔符不会分隔 value, 返回的 value 会去掉引号, 引号内两个连续的引号表示一个引号. 为 0 表示不启用, 不能是 Delim 中的字符
+	Escape                  byte                    // 转义字符, 设置后转义字符之后的一个字节(包括分隔符, 引号和转义字符本身)会按原样保留, 返回的 value 会去掉转义字符. 为 0 表示不启用, 不能是 Delim 中的字符或者 Quote
+	DelimMatch              DelimMatchFunc          // 分隔符匹配函数, 用于长度可变的分隔符, 设置后按它切分 value, 此时 Delim 只作为 chunk 中 value 之间的分隔符, 并且忽略 Quote 和 Escape
+	OutputDelim             []byte                  // chunk 中 value 之间的分隔符, 为空时使用 Delim. chunk 长度按这个分隔符计算
+	OutputDelimEscape       []byte                  // 设置 OutputDelim 时, value 中出现的 OutputDelim 会被替换为这个值, 为空时 RunSplit 会返回 ErrValueContainsOutputDelim
+	ChunkJoiner             ChunkJoiner             // 自定义 value 写入 chunk 的方式, value 之间仍然使用 OutputDelim 分隔, 设置后不会检查 value 中的 OutputDelim. chunk 长度按 ChunkJoiner 实际写入的数据计算
+	ChunkFormat             ChunkFormat             // chunk 数据的格式, 默认为 ChunkFormatRaw. 为 ChunkFormatJSONArray 时会忽略 OutputDelim, KeepTrailingDelim, ChunkJoiner 和 MinLastChunkSize, chunk 长度按编码后的长度计算
+	RejectInvalidUTF8       bool                    // ChunkFormatJSONArray 时 value 不是合法的 UTF-8 是否返回 ErrInvalidUTF8Value, 否则不合法的字节会被替换为 \ufffd
+	CompressChunks          bool                    // 是否使用 gzip 压缩 ChunkData, 在 ChunkHeader 和 ChunkFooter 写入后压缩. ChunkSizeLimit 和 Checksum 仍然按压缩前的数据计算. 流式 flush 和 OmitChunkData 时无效
+	CompressLevel           int                     // gzip 压缩级别, 0 时使用 gzip.DefaultCompression, 不合法时 NewSplitter 会 panic, NewSplitterE 会返回 ErrInvalidCompressLevel
+	EncodeChunkBase64       bool                    // 是否使用 base64 编码 ChunkData, 开启 CompressChunks 时先压缩再编码. ChunkSizeLimit 和 Checksum 仍然按编码前的数据计算. 流式 flush 和 OmitChunkData 时无效
+	Base64Encoding          *base64.Encoding        // base64 编码方式, 为 nil 时使用 base64.StdEncoding, 可以设置为 base64.URLEncoding 等
+	ChunkTransformers       []ChunkTransformer      // 依次对 ChunkData 调用的转换函数, 在 ChunkHeader, ChunkFooter, CompressChunks 和 EncodeChunkBase64 之后调用. ChunkSizeLimit 和 Checksum 仍然按转换前的数据计算. 流式 flush 和 OmitChunkData 时无效
+	ChunkHeader             ChunkDecorator          // 返回写入 ChunkData 开头的数据, 在调用 FlushChunkHandler 前调用, 此时 args 中除 ChunkData 和 Checksum 外的字段都是最终的值. 流式 flush 和 OmitChunkData 时无效
+	ChunkFooter             ChunkDecorator          // 返回写入 ChunkData 末尾的数据, 同 ChunkHeader
+	HeaderFooterInSizeLimit bool                    // ChunkSizeLimit 是否包含 ChunkHeader 和 ChunkFooter 的长度, 开启后每次写入 value 前都会额外调用 ChunkHeader 和 ChunkFooter 计算长度
+	ChunkSizeLimit          int                     // chunk 长度限制, 一个chunk的长度(不包含末尾的分隔符)不会超过这个值, 但是value超出chunk长度时会作为一个chunk, 此时chunk长度会超出这个值
+	FlushChunkHandler       FlushChunkHandler       // flushChunk函数
+	FlushChunkCtxHandler    FlushChunkCtxHandler    // 带 ctx 的 flushChunk 函数, 设置后会忽略 FlushChunkHandler. StopAndFlush 后 flush 的剩余数据收到的 ctx 不会被取消
+	FlushChunkStreamHandler FlushChunkStreamHandler // 流式 flushChunk 函数, 设置后会忽略 FlushChunkHandler, FlushConcurrency 和 IncludeValues
+	DefaultHandlerWriter    io.Writer               // 没有设置任何 flush 函数时, 将 ChunkData 和 value 之间的分隔符依次写入这个 writer, 开启 KeepTrailingDelim 时不再额外写入分隔符. 为 nil 时写入标准输出
+	ValueMaxScanSizeLimit   int                     // value 最大扫描长度限制, 如果扫描一定长度还无法确认一个完整的value则返回错误
+	ReadBufferSize          int                     // 从 rd 读取时的缓冲区大小, 读取大 value 或者高吞吐的数据源时可以调大以减少 Read 调用次数, <=0 时使用 DefaultReadBufferSize
+	DecompressGzip          bool                    // 是否使用 gzip 解压 rd 后再分隔, 支持多个 gzip 流拼接的数据. ScanByteNum 和偏移都按解压后的数据计算
+	AllowValueGrow          bool                    // value 超过 ValueMaxScanSizeLimit 时是否允许扩容读取缓冲区(每次翻倍), 直到超过 ValueHardCapLimit 才返回错误
+	ValueHardCapLimit       int                     // 允许扩容时 value 最大扫描长度的硬上限, 不大于 ValueMaxScanSizeLimit 时表示不扩容
+	ValueFilter             ValueFilter             // value过滤器
+	ValueSnFilter           ValueSnFilter           // 带 sn 的 value 过滤器, 设置后会忽略 ValueFilter
+	ValueFilterE            ValueFilterE            // 可以返回错误的 value 过滤器, 返回错误时会停止运行. 设置后会忽略 ValueFilter, 设置 ValueSnFilter 时无效
+	ChunkFilter             ChunkFilter             // chunk 过滤器, 在 ChunkHeader 等处理和 FlushChunkHandler 之前调用, 返回 false 时跳过这个 chunk, 跳过的 chunk 仍然占用 ChunkSn. 流式 flush 时无效
+	ChunkIDFunc             ChunkIDFunc             // chunk ID 生成函数, 在 ChunkFilter 和 FlushChunkHandler 之前对每个 chunk 调用一次, 结果写入 FlushChunkArgs.ChunkID, 重试时不会重新生成. 为 nil 时使用 ChunkSn 的十进制字符串
+	ValueHandler            ValueHandler            // value 回调, 在 ValueFilter 之后对保留的 value 调用, 可用于记录每个 value 的偏移
+	Dedup                   bool                    // 是否丢弃本次运行中已出现过的 value, 在 ValueFilter 之后, ValueHandler 之前处理, 被丢弃的 value 不占用 sn
+	DedupMaxEntries         int                     // 去重时最多记录的 value 数, 超过时淘汰最久未出现的 value, 此时很久之前出现过的 value 可能不会被丢弃. <=0 表示不限制
+	RateLimit               int                     // 限速器, 限制每秒扫描字节数, 爆发量为其十分之一, <=0 表示不限速
+	ChunkValueCountLimit    int                     // chunk 的 value 数量限制, 和 ChunkSizeLimit 任意一个达到时都会 flush, <=0 表示不限制
+	MinChunkValueCount      int                     // chunk 的最少 value 数, chunk 中的 value 数少于这个值时不会因为 ChunkSizeLimit 而 flush, 此时 chunk 长度可能超过 ChunkSizeLimit. <=0 表示不限制
+	PerValueChunks          bool                    // 是否每个 value 作为一个 chunk, 开启后每个 value 会在读取到下一个 value 或 EOF 时 flush, 忽略 chunk 的长度和 value 数量限制
+	PartitionKey            PartitionKeyFunc        // 分区函数, 和 PartitionCount 一起设置时每个 value 会按返回值对 PartitionCount 取模写入对应分区的 chunk, 每个分区的 chunk 独立 flush. 设置 FlushChunkStreamHandler 时无效
+	PartitionCount          int                     // 分区数, <=1 表示不分区
+	FlushPolicy             FlushPolicy             // flush 策略, 其中设置的字段会覆盖 ChunkSizeLimit, ChunkValueCountLimit 和 MaxChunkInterval
+	SkipValueCount          int                     // 丢弃开头的 value 数, 在 ValueFilter 之前丢弃, 不包括空 value. 被丢弃的 value 仍然会占用 sn, 可用于跳过表头
+	MaxValueCount           int64                   // 最多写入 chunk 的 value 数, 达到后会 flush 当前 chunk 并正常结束, RunSplit 返回 nil. <=0 表示不限制
+	MaxChunkCount           int                     // 最多 flush 的 chunk 数, 达到后会停止读取并正常结束, RunSplit 返回 nil, 剩余的数据会被丢弃. <=0 表示不限制
+	MergeRemainder          bool                    // 设置 MaxChunkCount 时, 是否将剩余的数据全部写入最后一个 chunk 而不是丢弃, 此时最后一个 chunk 会忽略所有 flush 限制直到 EOF
+	MinLastChunkSize        int                     // 最后一个 chunk 的最小长度, 小于这个值时会合并到前一个 chunk 中, 即使超过 ChunkSizeLimit. 开启后每个 chunk 会延迟到下一个 chunk flush 时才 flush, 流式 flush 时无效. <=0 表示不启用
+	LookaheadLastChunk      bool                    // 是否将每个 chunk 延迟到下一个 chunk flush 或者运行结束时才 flush, 保证正常结束时最后交给 handler 的 chunk 的 IsLastChunk 为 true. 流式 flush 时无效
+	KeepTrailingDelim       bool                    // 是否保留 ChunkData 末尾的分隔符, 开启后每个 chunk 都以分隔符结尾, 只有 rd 不以分隔符结尾时最后一个 chunk 除外. chunk 长度计算包含这个分隔符
+	TrimSpace               bool                    // 是否去掉 value 首尾的 ASCII 空白字符, 在 ValueFilter 之前处理, 去掉后为空的 value 会被丢弃
+	TrimCR                  bool                    // 是否去掉 value 末尾的一个 "\r", 用于处理 CRLF 换行的数据, 在 TrimSpace 之前处理
+	ValuePrefixTrim         []byte                  // 去掉 value 开头的这个前缀, 在 TrimSpace 之后, SkipValueCount 和 ValueFilter 之前处理. 去掉后为空的 value 会被丢弃(开启 KeepEmptyValues 时保留), 为空表示不启用
+	DropUnprefixedValues    bool                    // 设置 ValuePrefixTrim 时, 是否丢弃不以这个前缀开头的 value, 默认原样保留
+	KeepEmptyValues         bool                    // 是否保留分隔符之间的空 value, 保留时会占用 sn 并写入 chunk. 此时 ValueFilter 返回 nil 会丢弃 value, 返回空字节会保留为空 value
+	CountFilteredValues     bool                    // 被丢弃的 value(即计入 DiscardedValueNum 的 value)是否也占用 sn, 开启后 sn 为 value 在 rd 中的序号(从 0 开始), 此时 chunk 的 StartValueSn 和 EndValueSn 之间可能有间隔
+	EnableChecksum          bool                    // 是否计算 ChunkData 的校验和, 使用 crc32.ChecksumIEEE, 在写入 value 时增量计算
+	ChecksumFunc            ChecksumFunc            // 自定义校验和函数, 设置后会在 flush 时对 ChunkData 计算校验和, 此时忽略 EnableChecksum
+	IncludeValues           bool                    // 是否在 FlushChunkArgs.Values 中提供 chunk 中的每个 value
+	OmitChunkData           bool                    // 开启 IncludeValues 时是否不提供 FlushChunkArgs.ChunkData
+	DisableChunkCopy        bool                    // 禁用 chunk 数据的复制, ChunkData 和 Values 会直接复用内部 chunk 缓冲区, 仅在 FlushChunkHandler(和 OrderedFlushHandler) 返回前有效. 并发 flush 或 RunSplitChan 时无效
+	PoolChunkData           bool                    // 是否从 sync.Pool 获取 ChunkData 的缓冲区, 使用完后调用 FlushChunkArgs.Release 归还, 可以在 handler 返回后继续持有
+	Timeout                 time.Duration           // 运行超时, 超时后会中断读取并返回 *SplitTimeoutError, <=0 表示不限制
+	ReadTimeout             time.Duration           // 读取超时, 从 rd 读取时超过这个时间没有收到数据会返回 ErrReadStalled, 不包含限速等待的时间, <=0 表示不限制
+	IdleFlushInterval       time.Duration           // 空闲 flush 间隔, 超过这个时间没有新的 value 写入 chunk 且 chunk 不为空时会 flush 这个 chunk, <=0 表示不启用
+	MaxChunkInterval        time.Duration           // chunk 最大间隔, chunk 的第一个 value 写入后超过这个时间会 flush 这个 chunk, 即使没有达到长度限制, <=0 表示不启用
+	Follow                  bool                    // 跟随模式, 类似 tail -f, 读取到 EOF 后不会结束而是等待新的数据, 只能通过 Stop 或者 ctx 结束
+	FollowPollInterval      time.Duration           // 跟随模式下读取到 EOF 后的重试间隔, <=0 时使用 DefaultFollowPollInterval
+	ErrorHandler            ErrorHandler            // 读取 value 出错时的处理函数, 不设置时会停止运行并返回错误. 注意一直返回 ErrorActionContinue 时可能会一直重试
+	OnOversizeValue         OversizeValueHandler    // value 超过最大扫描长度时的回调, 返回 nil 时会丢弃这个 value 的剩余数据并继续读取, 否则停止运行并返回这个错误. 优先于 ErrorHandler
+	OnReadError             ReadErrorHandler        // 从 rd 读取出错时的回调(不包括 EOF, 停止和取消), 返回 true 时会重试读取, 已读取的数据会保留. 优先于 ErrorHandler
+	MaxReadRetries          int                     // 读取一个 value 时最多重试的次数, 超过后不会再调用 OnReadError, <=0 时使用 DefaultMaxReadRetries
+	FlushConcurrency        int                     // 并发调用 FlushChunkHandler 的 goroutine 数, >1 时启用. 此时 handler 可能不按 ChunkSn 顺序执行, RunSplit 会等待所有 handler 返回后才返回
+	OrderedFlush            bool                    // 是否在 FlushChunkHandler 返回后按 ChunkSn 顺序调用 OrderedFlushHandler, 用于并发 flush 时按顺序提交结果
+	OrderedFlushHandler     FlushChunkHandler       // 开启 OrderedFlush 时, 在 FlushChunkHandler 返回后按 ChunkSn 顺序在单个 goroutine 中调用
+	FlushRetry              FlushRetry              // FlushChunkHandler 返回错误时的重试配置, 零值表示不重试, 并发 flush 时每个 worker 独立重试. 不会重试 OrderedFlushHandler 和 FlushChunkStreamHandler
+	SpillThreshold          int64                   // chunk 数据长度超过这个值时写入 SpillDir 中的临时文件, 通过 FlushChunkArgs.SpillPath 和 SpillReader 提供而不是 ChunkData, <=0 表示不启用. 写入 chunk 时超过这个值后之后的 value 会直接追加到文件中. 开启 IncludeValues, MinLastChunkSize, ChunkHeader, ChunkFooter, ChecksumFunc, CompressChunks, EncodeChunkBase64 或 ChunkTransformers 时无效
+	SpillDir                string                  // spill 临时文件的目录, 为空时使用 os.TempDir()
+	MaxPendingChunks        int                     // 并发 flush 时最多积压(已提交但 handler 和 OrderedFlushHandler 还没有返回)的 chunk 数, 达到后会阻塞读取直到积压减少, <=0 表示不限制
+	MaxPendingBytes         int                     // 并发 flush 时最多积压的 chunk 数据字节数, 再提交一个 chunk 会超过时阻塞读取, 没有积压时总是允许提交. <=0 表示不限制
+	DisablePanicRecover     bool                    // 禁用 panic 恢复. 默认 FlushChunkHandler 和 ValueFilter 发生 panic 时会被恢复并由 RunSplit 返回 *HandlerPanicError
+	ProgressHandler         ProgressHandler         // 进度回调, 每扫描 ProgressInterval 字节调用一次, 读取到 EOF 时会再调用一次
+	TotalSize               int64                   // rd 的总字节数提示, 仅用于计算默认的 ProgressInterval, <=0 表示未知
+	ProgressInterval        int                     // 调用 ProgressHandler 的字节间隔, <=0 时如果设置了 TotalSize 则为其百分之一, 否则使用 DefaultProgressInterval
+	OnStart                 func() error            // 在开始读取前调用一次, 返回错误时会停止运行并由 RunSplit 返回这个错误
+	OnFinish                OnFinishHandler         // 在运行结束后调用一次(包括出错和停止), 此时最后一次 FlushChunkHandler 已经返回
+	OnSummary               func(RunSummary)        // 在运行结束后调用一次(包括出错和停止), 在 OnComplete 之后, OnFinish 之前, 此时最后一次 FlushChunkHandler 已经返回. 用于记录每次运行的汇总
+	OnComplete              func(stats Stats)       // 在运行成功完成(RunSplit 返回 nil)后调用一次, 在 OnFinish 之前, 此时最后一次 FlushChunkHandler 和 OrderedFlushHandler 已经返回, 没有 chunk 时也会调用. 出错和停止时不会调用
 }
 type splitter struct {
-	chunkSizeLimit    int           // chunk长度限制
-	chunkBuffer       *bytes.Buffer // chunk缓冲区
-	chunkSn           int           // chunk 编号
-	chunkStartValueSn int64         // chunk 的第一个 value 的 sn
-	nextValueSn       int64         // 下一个 value 的 sn
-	flushChunkHandler FlushChunkHandler
+	*chunkState                   // 当前写入的 chunk, 分区时指向当前分区的 chunk
+	partitions   []*chunkState    // 每个分区的 chunk, 不分区时为 nil
+	partitionKey PartitionKeyFunc // 分区函数
+
+	chunkSizeLimit          int          // chunk长度限制
+	chunkValueCountLimit    int          // chunk 的 value 数量限制
+	minChunkValueCount      int          // chunk 的最少 value 数
+	perValueChunks          bool         // 是否每个 value 作为一个 chunk
+	chunkSn                 int          // chunk 编号
+	enableChecksum          bool         // 是否增量计算 crc32 校验和
+	checksumFunc            ChecksumFunc // 自定义校验和函数
+	includeValues           bool         // 是否提供 chunk 中的每个 value
+	omitChunkData           bool         // 提供每个 value 时是否不提供 ChunkData
+	disableChunkCopy        bool         // 是否禁用 chunk 数据的复制
+	poolChunkData           bool         // 是否从缓冲区池获取 ChunkData 的缓冲区
+	nextValueSn             int64        // 下一个 value 的 sn
+	skipValueCount          int64        // 需要丢弃的开头的 value 数
+	skippedValueNum         int64        // 已丢弃的开头的 value 数
+	maxValueCount           int64        // 最多写入 chunk 的 value 数
+	maxChunkCount           int          // 最多 flush 的 chunk 数
+	mergeRemainder          bool         // 是否将剩余的数据全部写入最后一个 chunk
+	minLastChunkSize        int          // 最后一个 chunk 的最小长度
+	lookaheadLastChunk      bool         // 是否延迟一个 chunk flush 以标记最后一个 chunk
+	keepTrailingDelim       bool         // 是否保留 ChunkData 末尾的分隔符
+	trimSpace               bool         // 是否去掉 value 首尾的空白字符
+	trimCR                  bool         // 是否去掉 value 末尾的 \r
+	valuePrefixTrim         []byte       // 去掉 value 开头的前缀
+	dropUnprefixedValues    bool         // 是否丢弃不以 valuePrefixTrim 开头的 value
+	keepEmptyValues         bool         // 是否保留空 value
+	countFilteredValues     bool         // 被丢弃的 value 是否也占用 sn
+	flushChunkHandler       FlushChunkHandler
+	flushChunkStreamHandler FlushChunkStreamHandler
+	delimMatch              DelimMatchFunc // 分隔符匹配函数
 
-	delimiter             []byte      // 分隔符
-	valueMaxScanSizeLimit int         // value 最大扫描长度限制
-	valueFilter           ValueFilter // value过滤器
-	rateLimit             int         // 限速器, 限制每秒扫描字节数
+	delimiter             []byte        // 分隔符
+	quote                 byte          // 引号字符, 为 0 表示不启用
+	escape                byte          // 转义字符, 为 0 表示不启用
+	outputDelim           []byte        // chunk 中 value 之间的分隔符
+	checkOutputDelim      bool          // 是否检查 value 中的 outputDelim
+	outputDelimEscape     []byte        // value 中的 outputDelim 替换为这个值
+	chunkJoiner           ChunkJoiner   // 自定义 value 写入 chunk 的方式
+	joinBuffer            bytes.Buffer  // chunkJoiner 使用的临时缓冲区
+	chunkFormat           ChunkFormat   // chunk 数据的格式
+	chunkSuffix           []byte        // flush 时写入 chunk 末尾的数据
+	rejectInvalidUTF8     bool          // JSON 格式时是否拒绝不合法的 UTF-8
+	gzipWriter            *gzip.Writer  // 开启 CompressChunks 时用于压缩 ChunkData, 会被复用
+	gzipBuffer            bytes.Buffer  // gzipWriter 的输出缓冲区
+	valueMaxScanSizeLimit int           // value 最大扫描长度限制
+	readBufferSize        int           // 从 rd 读取时的缓冲区大小
+	valueHardCapLimit     int           // 允许扩容时 value 最大扫描长度的硬上限, 为 0 表示不扩容
+	valueFilter           filterFunc    // value过滤器
+	valueHandler          ValueHandler  // value 回调
+	dedup                 *dedupSet     // 开启去重时记录已出现过的 value
+	rateLimit             atomic.Int64  // 限速器, 限制每秒扫描字节数, 可能被 SetRateLimit 修改
+	timeout               time.Duration // 运行超时
+	readTimeout           time.Duration // 读取超时
+	idleFlushInterval     time.Duration // 空闲 flush 间隔
+	maxChunkInterval      time.Duration // chunk 最大间隔
+	follow                bool          // 跟随模式
+	decompressGzip        bool          // 是否使用 gzip 解压 rd
+	followPollInterval    time.Duration // 跟随模式下读取到 EOF 后的重试间隔
+	errorHandler          ErrorHandler
+	onOversizeValue       OversizeValueHandler
+	onReadError           ReadErrorHandler
+	maxReadRetries        int // 读取一个 value 时最多重试的次数
+	chunkHeader           ChunkDecorator
+	chunkFooter           ChunkDecorator
+	base64Encoding        *base64.Encoding
+	chunkTransformers     []ChunkTransformer
+	transformBuffers      [2]bytes.Buffer
+	chunkFilter           ChunkFilter
+	chunkIDFunc           ChunkIDFunc
+	headerFooterInLimit   bool // chunkSizeLimit 是否包含头部和尾部的长度
+	disablePanicRecover   bool
+	flushConcurrency      int
+	flushRetry            FlushRetry
+	spillThreshold        int64             // chunk 数据超过这个长度时写入临时文件, 为 0 表示不启用
+	spillDir              string            // spill 临时文件的目录
+	maxPendingChunks      int               // 并发 flush 时最多积压的 chunk 数
+	maxPendingBytes       int               // 并发 flush 时最多积压的 chunk 数据字节数
+	orderedFlushHandler   FlushChunkHandler // 开启 OrderedFlush 时才会设置
+	progressHandler       ProgressHandler
+	progressInterval      int64 // 调用 progressHandler 的字节间隔
+	onStart               func() error
+	onFinish              OnFinishHandler
+	onComplete            func(stats Stats)
+	onSummary             func(RunSummary)
 
-	started int32 // 是否已启动
-	stopped int32 // 是否已停止
+	started   int32                                   // 是否已启动
+	stopped   int32                                   // 是否已停止
+	stopFlush int32                                   // 停止时是否 flush 已缓冲的数据
+	cancel    atomic.Pointer[context.CancelCauseFunc] // 用于 Stop 中断正在进行的读取
+	finished  int32                                   // 是否已运行结束, 结束后才能读取 stats
+	stats     Stats                                   // 运行统计
+	vr        atomic.Pointer[valueReader]             // 当前运行的值读取器, 用于在运行中获取已扫描字节数
+	done      chan struct{}                           // 运行结束后关闭
+	lastErr   error                                   // 运行结束时返回的错误
+	startTime time.Time                               // 当前运行开始的时间
+
+	ctx     context.Context        // 当前运行的 ctx, Stop 时会被取消
+	chunkCh chan<- *FlushChunkArgs // 通过 RunSplitChan 运行时 chunk 会发送到这里而不是调用 flushChunkHandler
+	pool    *flushPool             // 并发 flush 时的工作池
+
+	pendingChunkNum atomic.Int64 // 并发 flush 时当前积压的 chunk 数
+	pendingByteNum  atomic.Int64 // 并发 flush 时当前积压的 chunk 数据字节数
+
+	chunkChan chan *FlushChunkArgs // 通过 NewChunkChanSplitter 创建时的 chunk chan, 每次运行结束时关闭
+
+	pauseMu  sync.Mutex
+	resumeCh chan struct{} // 暂停时不为 nil, 恢复时关闭
+	stopCh   chan struct{} // Stop 时关闭, 用于唤醒暂停中的等待, 运行前调用 Stop 时 ctx 还没有创建
+
+	nextProgress int64 // 下一次调用 progressHandler 的扫描字节数
+
+	conf Conf // 创建时的配置, RunSplitParallel 时用于为每个范围创建分隔器
 }
 
+// 创建分隔器, 配置不合法时会 panic
 func NewSplitter(conf Conf) Splitter {
+	s, err := NewSplitterE(conf)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// 创建分隔器, 配置不合法时返回错误而不是 panic, 用于校验用户提供的配置
+func NewSplitterE(conf Conf) (Splitter, error) {
 	if len(conf.Delim) == 0 {
-		panic("delim must not be empty")
+		return nil, ErrEmptyDelim
+	}
+	if conf.Quote != 0 && bytes.IndexByte(conf.Delim, conf.Quote) >= 0 {
+		return nil, ErrQuoteInDelim
+	}
+	if conf.Escape != 0 && (bytes.IndexByte(conf.Delim, conf.Escape) >= 0 || conf.Escape == conf.Quote) {
+		return nil, ErrInvalidEscape
 	}
 	s := &splitter{
-		chunkSizeLimit:    max(conf.ChunkSizeLimit, MinChunkSizeLimit),
-		chunkBuffer:       bytes.NewBuffer(make([]byte, 0, conf.ChunkSizeLimit)),
-		chunkSn:           0,
-		chunkStartValueSn: 0,
-		nextValueSn:       0,
-		flushChunkHandler: conf.FlushChunkHandler,
+		chunkSizeLimit:          max(conf.ChunkSizeLimit, MinChunkSizeLimit),
+		chunkValueCountLimit:    conf.ChunkValueCountLimit,
+		minChunkValueCount:      conf.MinChunkValueCount,
+		perValueChunks:          conf.PerValueChunks,
+		skipValueCount:          int64(conf.SkipValueCount),
+		maxValueCount:           conf.MaxValueCount,
+		maxChunkCount:           conf.MaxChunkCount,
+		mergeRemainder:          conf.MergeRemainder,
+		minLastChunkSize:        conf.MinLastChunkSize,
+		lookaheadLastChunk:      conf.LookaheadLastChunk,
+		keepTrailingDelim:       conf.KeepTrailingDelim,
+		trimSpace:               conf.TrimSpace,
+		trimCR:                  conf.TrimCR,
+		valuePrefixTrim:         conf.ValuePrefixTrim,
+		dropUnprefixedValues:    conf.DropUnprefixedValues && len(conf.ValuePrefixTrim) > 0,
+		keepEmptyValues:         conf.KeepEmptyValues,
+		countFilteredValues:     conf.CountFilteredValues,
+		includeValues:           conf.IncludeValues,
+		omitChunkData:           conf.IncludeValues && conf.OmitChunkData,
+		disableChunkCopy:        conf.DisableChunkCopy,
+		poolChunkData:           conf.PoolChunkData,
+		enableChecksum:          conf.EnableChecksum && conf.ChecksumFunc == nil,
+		checksumFunc:            conf.ChecksumFunc,
+		chunkState:              newChunkState(0, conf.ChunkSizeLimit),
+		chunkSn:                 0,
+		nextValueSn:             0,
+		flushChunkHandler:       conf.FlushChunkHandler,
+		flushChunkStreamHandler: conf.FlushChunkStreamHandler,
+		conf:                    conf,
 
 		delimiter:             conf.Delim,
+		quote:                 conf.Quote,
+		escape:                conf.Escape,
+		delimMatch:            conf.DelimMatch,
+		outputDelim:           conf.Delim,
+		outputDelimEscape:     conf.OutputDelimEscape,
 		valueMaxScanSizeLimit: max(conf.ValueMaxScanSizeLimit, MinValueMaxScanSizeLimit),
-		valueFilter:           conf.ValueFilter,
-		rateLimit:             conf.RateLimit,
+		readBufferSize:        conf.ReadBufferSize,
+		valueHandler:          conf.ValueHandler,
+		timeout:               conf.Timeout,
+		readTimeout:           conf.ReadTimeout,
+		idleFlushInterval:     conf.IdleFlushInterval,
+		maxChunkInterval:      conf.MaxChunkInterval,
+		follow:                conf.Follow,
+		decompressGzip:        conf.DecompressGzip,
+		followPollInterval:    conf.FollowPollInterval,
+		errorHandler:          conf.ErrorHandler,
+		onOversizeValue:       conf.OnOversizeValue,
+		onReadError:           conf.OnReadError,
+		chunkFormat:           conf.ChunkFormat,
+		rejectInvalidUTF8:     conf.RejectInvalidUTF8,
+		chunkHeader:           conf.ChunkHeader,
+		chunkFooter:           conf.ChunkFooter,
+		chunkTransformers:     conf.ChunkTransformers,
+		chunkFilter:           conf.ChunkFilter,
+		chunkIDFunc:           conf.ChunkIDFunc,
+		headerFooterInLimit:   conf.HeaderFooterInSizeLimit,
+		maxReadRetries:        conf.MaxReadRetries,
+		disablePanicRecover:   conf.DisablePanicRecover,
+		flushConcurrency:      conf.FlushConcurrency,
+		flushRetry:            conf.FlushRetry,
+		maxPendingChunks:      conf.MaxPendingChunks,
+		maxPendingBytes:       conf.MaxPendingBytes,
+		progressHandler:       conf.ProgressHandler,
+		progressInterval:      int64(conf.ProgressInterval),
+		onStart:               conf.OnStart,
+		onFinish:              conf.OnFinish,
+		onComplete:            conf.OnComplete,
+		onSummary:             conf.OnSummary,
+	}
+	s.done = make(chan struct{})
+	s.rateLimit.Store(int64(conf.RateLimit))
+	switch {
+	case conf.ValueSnFilter != nil:
+		s.valueFilter = func(sn int64, value []byte) ([]byte, error) { return conf.ValueSnFilter(sn, value), nil }
+	case conf.ValueFilterE != nil:
+		s.valueFilter = func(_ int64, value []byte) ([]byte, error) { return conf.ValueFilterE(value) }
+	case conf.ValueFilter != nil:
+		s.valueFilter = func(_ int64, value []byte) ([]byte, error) { return conf.ValueFilter(value), nil }
+	}
+	if conf.Dedup {
+		s.dedup = newDedupSet(conf.DedupMaxEntries)
+	}
+	if conf.PartitionKey != nil && conf.PartitionCount > 1 && conf.FlushChunkStreamHandler == nil {
+		s.partitionKey = conf.PartitionKey
+		s.partitions = make([]*chunkState, conf.PartitionCount)
+		s.partitions[0] = s.chunkState
+		for i := 1; i < conf.PartitionCount; i++ {
+			s.partitions[i] = newChunkState(i, conf.ChunkSizeLimit)
+		}
+		// 分区时最后一个允许的 chunk 不确定属于哪个分区, 无法合并剩余数据
+		s.mergeRemainder = false
+	}
+	if len(conf.OutputDelim) > 0 {
+		s.outputDelim = conf.OutputDelim
+		s.checkOutputDelim = true
+	}
+	if conf.ChunkJoiner != nil {
+		// 由 chunkJoiner 负责转义 value
+		s.chunkJoiner = conf.ChunkJoiner
+		s.checkOutputDelim = false
+	}
+	if conf.CompressChunks {
+		level := conf.CompressLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err := gzip.NewWriterLevel(nil, level)
+		if err != nil {
+			return nil, fmt.Errorf("%w: level=%d", ErrInvalidCompressLevel, conf.CompressLevel)
+		}
+		s.gzipWriter = w
+	}
+	if conf.EncodeChunkBase64 {
+		s.base64Encoding = conf.Base64Encoding
+		if s.base64Encoding == nil {
+			s.base64Encoding = base64.StdEncoding
+		}
+	}
+	if conf.ChunkFormat == ChunkFormatJSONArray {
+		s.chunkJoiner = s.appendJSONValue
+		s.chunkSuffix = []byte("]")
+		s.outputDelim = nil
+		s.checkOutputDelim = false
+		s.keepTrailingDelim = false
+		s.minLastChunkSize = 0 // 合并后不再是一个 JSON 数组
+	}
+	if conf.AllowValueGrow {
+		s.valueHardCapLimit = conf.ValueHardCapLimit
+	}
+	if conf.OrderedFlush {
+		s.orderedFlushHandler = conf.OrderedFlushHandler
+	}
+	if conf.FlushPolicy.MaxBytes > 0 {
+		s.chunkSizeLimit = max(conf.FlushPolicy.MaxBytes, MinChunkSizeLimit)
+	}
+	if conf.FlushPolicy.MaxValues > 0 {
+		s.chunkValueCountLimit = conf.FlushPolicy.MaxValues
+	}
+	if conf.FlushPolicy.MaxAge > 0 {
+		s.maxChunkInterval = conf.FlushPolicy.MaxAge
+	}
+	if s.maxReadRetries <= 0 {
+		s.maxReadRetries = DefaultMaxReadRetries
+	}
+	if s.followPollInterval <= 0 {
+		s.followPollInterval = DefaultFollowPollInterval
+	}
+	if s.progressInterval <= 0 {
+		s.progressInterval = DefaultProgressInterval
+		if conf.TotalSize > 0 {
+			s.progressInterval = max(conf.TotalSize/100, 1)
+		}
+	}
+	// 这些配置需要在内存中处理 chunk 数据, 此时不会 spill
+	if conf.SpillThreshold > 0 && !conf.IncludeValues && conf.MinLastChunkSize <= 0 && conf.ChunkHeader == nil && conf.ChunkFooter == nil &&
+		conf.ChecksumFunc == nil && !conf.CompressChunks && !conf.EncodeChunkBase64 && len(conf.ChunkTransformers) == 0 {
+		s.spillThreshold = conf.SpillThreshold
+		s.spillDir = conf.SpillDir
+	}
+	if conf.FlushChunkCtxHandler != nil {
+		h := conf.FlushChunkCtxHandler
+		s.flushChunkHandler = func(args *FlushChunkArgs) error {
+			ctx := s.flushContext(args)
+			return s.ctxHandlerErr(ctx, h(ctx, args))
+		}
+	}
+	if s.chunkIDFunc == nil {
+		s.chunkIDFunc = defaultChunkID
 	}
 	if s.flushChunkHandler == nil {
-		s.flushChunkHandler = defaultFlushChunkHandler
+		w := conf.DefaultHandlerWriter
+		if w == nil {
+			w = os.Stdout
+		}
+		sep := s.outputDelim
+		if s.keepTrailingDelim {
+			sep = nil
+		}
+		s.flushChunkHandler = WriterFlushChunkHandler(w, sep)
 	}
-	return s
+	return s, nil
 }
 
 // 运行分隔
 func (s *splitter) RunSplit(rd io.Reader) error {
+	return s.RunSplitContext(context.Background(), rd)
+}
+
+// 运行分隔, 可以通过 ctx 取消
+func (s *splitter) RunSplitContext(ctx context.Context, rd io.Reader) error {
 	// 防止重复调用
 	if atomic.AddInt32(&s.started, 1) != 1 {
 		return ErrSplitterIsStarted
 	}
+	return s.run(ctx, rd)
+}
+
+// 异步运行分隔
+func (s *splitter) RunSplitAsync(rd io.Reader) <-chan error {
+	errCh := make(chan error, 1)
+	// 在启动 goroutine 前检查, 保证重复调用时能立即得到错误
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		errCh <- ErrSplitterIsStarted
+		return errCh
+	}
+
+	go func() {
+		errCh <- s.run(context.Background(), rd)
+	}()
+	return errCh
+}
+
+// 开始运行, 返回的 end 函数需要在运行结束时调用
+func (s *splitter) begin(ctx context.Context, rd io.Reader) (context.Context, *cancelReader, *valueReader, func(err error)) {
+	var vr *valueReader
+	ctx, end := s.beginContext(ctx, func() int64 { return vr.GetScanByteNum() })
 
 	// 创建值读取器
-	vr := NewValueReaderAndLimiter(rd, s.delimiter, s.valueMaxScanSizeLimit, s.rateLimit)
+	if s.decompressGzip {
+		rd = &gzipInputReader{rd: rd}
+	}
+	cr := newCancelReader(ctx, rd, s.readTimeout)
+	cr.follow = s.follow
+	cr.followPollInterval = s.followPollInterval
+	vr = newValueReader(ctx, cr, s.delimiter, s.valueMaxScanSizeLimit, int(s.rateLimit.Load()), s.readBufferSize)
+	vr.valueHardCapLimit = s.valueHardCapLimit
+	vr.quote = s.quote
+	vr.escape = s.escape
+	vr.delimMatch = s.delimMatch
+	s.vr.Store(vr)
+	vr.SetRateLimit(int(s.rateLimit.Load())) // 创建 vr 期间可能调用了 SetRateLimit
+	s.nextProgress = s.progressInterval
+	return ctx, cr, vr, end
+}
+
+// 创建运行使用的 ctx, 返回的 end 函数需要在运行结束时调用, scanByteNum 返回结束时已扫描rd的字节数
+func (s *splitter) beginContext(ctx context.Context, scanByteNum func() int64) (context.Context, func(err error)) {
+	cancelTimeout := context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, s.timeout, ErrSplitTimeout)
+	}
+
+	// Stop 时通过 cancel 中断阻塞中的读取
+	ctx, cancel := context.WithCancelCause(ctx)
+	s.cancel.Store(&cancel)
+	s.ctx = ctx
+	s.startTime = time.Now()
+	if s.chunkChan != nil {
+		s.chunkCh = s.chunkChan
+	}
+
+	// 回调中可能调用 Reset, 这里提前取出本次运行的 done 和 chunkChan
+	done, chunkChan := s.done, s.chunkChan
+	end := func(err error) {
+		cancel(nil)
+		cancelTimeout()
+		s.finishStats(scanByteNum())
+		s.lastErr = err
+		if err == nil && s.onComplete != nil {
+			s.onComplete(s.stats)
+		}
+		if s.onSummary != nil {
+			s.onSummary(s.runSummary(err))
+		}
+		if s.onFinish != nil {
+			s.onFinish(err, s.stats.ChunkNum, s.stats.ValueNum)
+		}
+		if chunkChan != nil {
+			close(chunkChan)
+		}
+		close(done)
+	}
+	return ctx, end
+}
 
+func (s *splitter) run(ctx context.Context, rd io.Reader) (err error) {
+	ctx, cr, vr, end := s.begin(ctx, rd)
+	defer func() { end(err) }()
+
+	// 并发 flush 时需要等待所有 handler 返回, handler 的错误优先于其导致的取消错误
+	if s.flushConcurrency > 1 && s.chunkCh == nil && !s.isStreaming() {
+		s.pool = newFlushPool(s, s.flushConcurrency, s.orderedFlushHandler, *s.cancel.Load())
+		defer func() {
+			if pErr := s.pool.wait(); pErr != nil {
+				err = pErr
+			}
+			s.pool = nil
+		}()
+	}
+
+	if s.isStreaming() {
+		defer func() { s.abortChunkStream(err) }()
+	}
+	if s.spillThreshold > 0 {
+		defer func() { _ = s.eachPartition(s.discardSpill) }()
+	}
+	if s.holdsChunk() {
+		defer func() {
+			isLast := err == nil
+			if pErr := s.eachPartition(func() error { return s.flushPendingChunk(isLast) }); pErr != nil && err == nil {
+				err = pErr
+			}
+		}()
+	}
+	if s.perValueChunks {
+		// 停止或出错时 flush 还未 flush 的 value, 它不是最后一个 chunk. 返回原来的错误
+		defer func() {
+			if err == nil || s.isStreaming() {
+				return
+			}
+			scanByteNum := vr.GetScanByteNum()
+			_ = s.eachPartition(func() error {
+				return s.flushChunkBuffer(scanByteNum, FlushReasonValueLimit)
+			})
+		}()
+	}
+
+	if s.onStart != nil {
+		if err = s.onStart(); err != nil {
+			return err
+		}
+	}
+
+	var valueEndScanByteNum int64 // 最后写入 chunk 的 value 结束时扫描的字节数
 	for {
-		if atomic.LoadInt32(&s.stopped) > 0 {
-			return ErrSplitterIsStopped
+		// 达到 chunk 数限制时丢弃剩余的数据
+		if s.reachMaxChunkCount() {
+			return nil
 		}
 
-		scanByteNum := vr.GetScanByteNum() // 当前已扫描的字节数
-		value, err := vr.Next()            // 获取下一个值
+		value, scanByteNum, err := s.nextValue(ctx, vr)
+		if err == errIdleDeadline {
+			// 空闲超时或者达到 chunk 最大间隔, flush 当前 chunk. 未读取完的 value 会在下次 Next 时继续读取
+			err = s.eachPartition(func() error {
+				reason := FlushReasonIdle
+				if s.maxChunkInterval > 0 && time.Since(s.chunkStartTime) >= s.maxChunkInterval {
+					reason = FlushReasonAgeLimit
+				} else if s.idleFlushInterval <= 0 {
+					return nil // 分区时其他分区的 chunk 可能还没有达到最大间隔
+				}
+				return s.flushChunkBuffer(valueEndScanByteNum, reason)
+			})
+			if err != nil {
+				return err
+			}
+			cr.SetIdleDeadline(s.partitionFlushDeadline())
+			continue
+		}
 		if err != nil && err != io.EOF {
 			return err
 		}
 
-		if s.valueFilter != nil && len(value) > 0 {
-			value = s.valueFilter(value)
+		// 读取到下一个 value 后才 flush 上一个 value, 这样 EOF 时最后一个 value 可以被标记为 IsLastChunk
+		if value != nil && s.perValueChunks {
+			if err := s.eachPartition(func() error {
+				return s.flushChunkBuffer(scanByteNum, FlushReasonValueLimit)
+			}); err != nil {
+				return err
+			}
+			if s.reachMaxChunkCount() {
+				return nil
+			}
 		}
 
-		if len(value) > 0 {
+		if value != nil && s.checkOutputDelim {
+			if value, err = s.escapeOutputDelim(value, err); err != nil && err != io.EOF {
+				return err
+			}
+		}
+		if value != nil && s.partitions != nil {
+			s.usePartition(s.partitionKey(value))
+		}
+		if value != nil && s.chunkFormat == ChunkFormatJSONArray {
+			if err = s.checkJSONValue(value, err); err != nil && err != io.EOF {
+				return err
+			}
+		}
+		if value != nil {
+			raw := value
+			if s.chunkJoiner != nil {
+				value = s.joinValue(value, s.chunkValueNum)
+			}
 			// 如果加入这个 value 会超过 限制，则先 flush 当前 chunk
-			if s.chunkBuffer.Len()+len(value) > s.chunkSizeLimit && s.chunkBuffer.Len() > 0 {
-				chunkSn := s.chunkSn
-				s.chunkSn++
-				s.flushChunk(&FlushChunkArgs{
-					ChunkSn:      chunkSn,
-					StartValueSn: s.chunkStartValueSn,
-					EndValueSn:   s.nextValueSn - 1,
-					ChunkData:    s.chunkBuffer.Bytes(),
-					ScanByteNum:  scanByteNum, // 这个值应该是获取当前value之前扫描的字节数
-				})
-				s.chunkBuffer.Reset()
-				s.chunkStartValueSn = s.nextValueSn
+			if reason, ok := s.needFlush(len(value)); ok {
+				// 这个值应该是获取当前value之前扫描的字节数
+				if err := s.flushChunkBuffer(scanByteNum, reason); err != nil {
+					return err
+				}
+				if s.reachMaxChunkCount() {
+					return nil
+				}
+				if s.chunkFormat == ChunkFormatJSONArray && s.chunkValueNum == 0 {
+					value[0] = '[' // 成为下一个 chunk 的第一个 value
+				}
 			}
 
+			if s.chunkValueNum == 0 {
+				s.chunkStartValueSn = s.nextValueSn
+				s.chunkStartOffset = vr.valueStart
+				s.chunkStartTime = time.Now()
+			}
+			if s.enableChecksum {
+				// 最后一个分隔符在 flush 时会被去掉, 所以在写入下一个 value 时再计算前一个分隔符
+				if s.chunkValueNum > 0 {
+					s.chunkChecksum = crc32.Update(s.chunkChecksum, crc32.IEEETable, s.outputDelim)
+				}
+				s.chunkChecksum = crc32.Update(s.chunkChecksum, crc32.IEEETable, value)
+			}
 			s.chunkBuffer.Write(value)
-			s.chunkBuffer.Write(s.delimiter) // 写入值后要写入分隔符
+			if s.includeValues {
+				if s.chunkFormat == ChunkFormatJSONArray {
+					// Values 中提供编码前的 value
+					s.chunkRawValues = append(s.chunkRawValues, raw...)
+					s.chunkValueEnds = append(s.chunkValueEnds, len(s.chunkRawValues))
+				} else {
+					s.chunkValueEnds = append(s.chunkValueEnds, s.chunkBuffer.Len())
+				}
+				if s.delimMatch != nil {
+					s.chunkDelims = append(s.chunkDelims, vr.matchedDelim...)
+					s.chunkDelimEnds = append(s.chunkDelimEnds, len(s.chunkDelims))
+				}
+			}
+			s.chunkBuffer.Write(s.outputDelim) // 写入值后要写入分隔符
+			// 读取到 EOF 时返回的 value 之后没有分隔符
+			s.chunkEndsWithDelim = !vr.isEOF
+			if s.isStreaming() {
+				if err := s.writeChunkStream(); err != nil {
+					return err
+				}
+			} else if s.spillThreshold > 0 {
+				if err := s.spillChunkBuffer(); err != nil {
+					return err
+				}
+			}
+			s.chunkEndValueSn = s.nextValueSn
 			s.nextValueSn++
+			s.chunkValueNum++
+			s.stats.ValueNum++
+
+			valueEndScanByteNum = vr.GetScanByteNum()
+			s.chunkEndOffset = valueEndScanByteNum
+			cr.SetIdleDeadline(s.chunkFlushDeadline())
+
+			// 达到 value 数限制时作为最后一个 chunk flush 并结束
+			if s.reachMaxValueCount() {
+				return s.eachPartition(func() error {
+					return s.flushChunkBuffer(valueEndScanByteNum, FlushReasonMaxValueCount)
+				})
+			}
 		}
 
 		// 在 EOF 时处理最后一个 chunk
 		if err == io.EOF {
-			if s.chunkBuffer.Len() > 0 {
-				chunkSn := s.chunkSn
-				s.chunkSn++
-				s.flushChunk(&FlushChunkArgs{
-					ChunkSn:      chunkSn,
-					StartValueSn: s.chunkStartValueSn,
-					EndValueSn:   s.nextValueSn - 1,
-					ChunkData:    s.chunkBuffer.Bytes(),
-					ScanByteNum:  vr.GetScanByteNum(),
-				})
-				s.chunkBuffer.Reset()
+			scanByteNum := vr.GetScanByteNum()
+			err = s.eachPartition(func() error {
+				return s.flushChunkBuffer(scanByteNum, FlushReasonEOF)
+			})
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// 读取下一个 value 并过滤, 返回的 value 为 nil 表示没有需要保留的 value, 开启 keepEmptyValues 时保留的空 value 不为 nil. 读取到末尾时返回 io.EOF, 此时 value 可能不为空.
+// scanByteNum 为读取这个 value 之前已扫描的字节数
+func (s *splitter) nextValue(ctx context.Context, vr *valueReader) (value []byte, scanByteNum int64, err error) {
+	s.waitResume(ctx)
+
+	scanByteNum = vr.GetScanByteNum() // 当前已扫描的字节数
+	if err = s.checkStop(ctx, scanByteNum); err != nil {
+		return nil, scanByteNum, err
+	}
+
+	value, err = vr.Next() // 获取下一个值
+	readAttempt := 0       // 读取当前 value 时出错的次数
+	for {
+		// 停止或取消后不再处理这个 value
+		if cErr := s.checkStop(ctx, scanByteNum); cErr != nil {
+			return nil, scanByteNum, cErr
+		}
+		if err == ErrValueReaderMaxScanSizeLimit && s.onOversizeValue != nil {
+			if oErr := s.onOversizeValue(value); oErr != nil {
+				return nil, scanByteNum, oErr
+			}
+			vr.skipValue()
+			value, err = vr.Next()
+			continue
+		}
+		if err == nil || err == io.EOF || err == errIdleDeadline {
+			break
+		}
+		if s.onReadError != nil && err != ErrValueReaderMaxScanSizeLimit && readAttempt < s.maxReadRetries {
+			readAttempt++
+			if s.onReadError(err, readAttempt) {
+				value, err = vr.Next()
+				continue
 			}
+		}
+		if s.errorHandler == nil {
+			break
+		}
+
+		action := s.errorHandler(err, vr.GetScanByteNum())
+		if action != ErrorActionContinue && action != ErrorActionSkip {
 			break
 		}
+		if action == ErrorActionSkip {
+			vr.skipValue()
+		}
+		value, err = vr.Next()
+	}
+	if err != nil && err != io.EOF {
+		return nil, scanByteNum, err
+	}
+	s.reportProgress(vr.GetScanByteNum(), err == io.EOF)
+
+	// 读取到末尾时最后一个分隔符之后的空 value 不是 rd 中的 value, 不计入 ScanValueNum
+	if len(value) > 0 || (err == nil && !vr.isEOF) {
+		s.stats.ScanValueNum++
+		s.stats.MaxValueSize = max(s.stats.MaxValueSize, len(value))
+	}
+
+	// 最后一个分隔符之后的空 value 总是会被丢弃, 不会占用 sn
+	if len(value) == 0 && (!s.keepEmptyValues || err != nil || vr.isEOF) {
+		if err == nil && !vr.isEOF {
+			s.dropValue()
+		}
+		return nil, scanByteNum, err
+	}
+
+	if s.trimCR && len(value) > 0 && value[len(value)-1] == '\r' {
+		// 只有 \r 的行视为空 value
+		value = value[:len(value)-1]
+		if len(value) == 0 && !s.keepEmptyValues {
+			s.dropValue()
+			return nil, scanByteNum, err
+		}
+	}
+	if s.trimSpace {
+		value = bytes.Trim(value, asciiSpace)
+		if len(value) == 0 && !s.keepEmptyValues {
+			s.dropValue()
+			return nil, scanByteNum, err
+		}
+		if value == nil {
+			value = []byte{} // bytes.Trim 可能返回 nil, 保留的空 value 不能为 nil
+		}
+	}
+
+	if len(s.valuePrefixTrim) > 0 {
+		trimmed, ok := bytes.CutPrefix(value, s.valuePrefixTrim)
+		if (!ok && s.dropUnprefixedValues) || (ok && len(trimmed) == 0 && !s.keepEmptyValues) {
+			s.dropValue()
+			return nil, scanByteNum, err
+		}
+		value = trimmed
+	}
+
+	// 丢弃开头的 value, 这些 value 会占用 sn
+	if s.skippedValueNum < s.skipValueCount {
+		s.skippedValueNum++
+		s.nextValueSn++
+		s.chunkStartValueSn = s.nextValueSn
+		return nil, scanByteNum, err
+	}
+
+	if s.valueFilter != nil {
+		var fErr error
+		value, fErr = s.callValueFilter(s.nextValueSn, value)
+		if fErr != nil {
+			return nil, scanByteNum, fErr
+		}
+		if value == nil || (len(value) == 0 && !s.keepEmptyValues) {
+			s.dropValue()
+			return nil, scanByteNum, err
+		}
+	}
+	if s.dedup != nil && s.dedup.seen(value) {
+		s.dropValue()
+		return nil, scanByteNum, err
+	}
+	if s.valueHandler != nil {
+		s.valueHandler(s.nextValueSn, vr.valueStart, value)
+	}
+	return value, scanByteNum, err
+}
+
+// 丢弃一个 value, 计入 DiscardedValueNum. 开启 countFilteredValues 时它仍然占用 sn
+func (s *splitter) dropValue() {
+	s.stats.DiscardedValueNum++
+	if s.countFilteredValues {
+		s.nextValueSn++
+	}
+}
+
+// 已扫描字节数达到下一个进度点时调用 progressHandler, 读取到 EOF 时总是调用
+func (s *splitter) reportProgress(scanByteNum int64, isEOF bool) {
+	if s.progressHandler == nil || (scanByteNum < s.nextProgress && !isEOF) {
+		return
+	}
+	s.progressHandler(scanByteNum)
+	s.nextProgress = (scanByteNum/s.progressInterval + 1) * s.progressInterval
+}
+
+// 如果已停止则返回 ErrSplitterIsStopped, 如果 ctx 已取消则返回包装后的错误.
+// 如果是通过 StopAndFlush 停止的, 会先 flush 已缓冲的数据, scanByteNum 为缓冲区中最后一个 value 结束时扫描的字节数
+func (s *splitter) checkStop(ctx context.Context, scanByteNum int64) error {
+	if atomic.LoadInt32(&s.stopped) > 0 {
+		if atomic.LoadInt32(&s.stopFlush) > 0 {
+			err := s.eachPartition(func() error {
+				return s.flushChunkBuffer(scanByteNum, FlushReasonStopped)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return ErrSplitterIsStopped
+	}
+	if err := ctx.Err(); err != nil {
+		if context.Cause(ctx) == ErrSplitTimeout {
+			return &SplitTimeoutError{ScanByteNum: scanByteNum, ChunkNum: s.chunkSn}
+		}
+		return fmt.Errorf("%w: %w", ErrSplitterIsCanceled, err)
 	}
 	return nil
 }
 
-func (s *splitter) flushChunk(args *FlushChunkArgs) {
+// 是否已达到 chunk 数限制, 合并剩余数据时总是返回 false
+func (s *splitter) reachMaxChunkCount() bool {
+	return s.maxChunkCount > 0 && !s.mergeRemainder && s.chunkSn >= s.maxChunkCount
+}
+
+// 是否已达到 value 数限制
+func (s *splitter) reachMaxValueCount() bool {
+	return s.maxValueCount > 0 && s.stats.ValueNum >= s.maxValueCount
+}
+
+// 加入一个长度为 valueLen 的 value 前检查是否需要先 flush 当前 chunk, 需要时返回原因
+func (s *splitter) needFlush(valueLen int) (FlushReason, bool) {
+	if s.chunkValueNum == 0 {
+		return 0, false
+	}
+	// 缓冲区末尾的分隔符在加入 value 后会成为 value 之间的分隔符, 而新的末尾分隔符会在 flush 时去掉,
+	// 所以这里得到的就是加入 value 后最终 ChunkData 的长度
+	size := s.chunkBuffer.Len() + s.spillN
+	if s.stream != nil {
+		size += s.stream.n
+	}
+	if s.keepTrailingDelim {
+		size += len(s.outputDelim)
+	}
+	size += len(s.chunkSuffix)
+	if s.headerFooterInLimit {
+		size += s.headerFooterLen()
+	}
+	if size+valueLen > s.chunkSizeLimit {
+		if s.chunkValueNum >= s.minChunkValueCount {
+			return FlushReasonSizeLimit, true
+		}
+		s.chunkSizeExceeded = true
+	}
+	if s.chunkValueCountLimit > 0 && s.chunkValueNum >= s.chunkValueCountLimit {
+		return FlushReasonValueLimit, true
+	}
+	if s.maxChunkInterval > 0 && time.Since(s.chunkStartTime) >= s.maxChunkInterval {
+		return FlushReasonAgeLimit, true
+	}
+	return 0, false
+}
+
+// 当前 chunk 因为空闲或者达到最大间隔需要 flush 的时间, 为零值表示不需要
+func (s *splitter) chunkFlushDeadline() time.Time {
+	var deadline time.Time
+	if s.idleFlushInterval > 0 {
+		deadline = time.Now().Add(s.idleFlushInterval)
+	}
+	if s.maxChunkInterval > 0 {
+		t := s.oldestChunkStartTime().Add(s.maxChunkInterval)
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+// flush 当前 chunk 缓冲区的数据, 缓冲区为空时不做任何事
+func (s *splitter) flushChunkBuffer(scanByteNum int64, reason FlushReason) error {
+	if s.chunkValueNum == 0 {
+		return nil
+	}
+	isLast := reason == FlushReasonEOF || reason == FlushReasonStopped || reason == FlushReasonMaxValueCount
+	if s.maxChunkCount > 0 && s.chunkSn == s.maxChunkCount-1 {
+		// 最后一个允许的 chunk, 合并剩余数据时只在结束时 flush
+		if s.mergeRemainder && !isLast {
+			return nil
+		}
+		isLast = true
+	}
+	if s.chunkSuffix != nil {
+		// 结束 chunk 的数据, 例如 JSON 数组的 "]"
+		s.chunkBuffer.Write(s.chunkSuffix)
+		if s.enableChecksum {
+			s.chunkChecksum = crc32.Update(s.chunkChecksum, crc32.IEEETable, s.chunkSuffix)
+		}
+		if s.stream != nil {
+			if err := s.writeChunkStream(); err != nil {
+				return err
+			}
+		}
+	}
+
+	delimSuffix := s.keepTrailingDelim && s.chunkEndsWithDelim
+	var checksum uint32
+	if s.enableChecksum {
+		checksum = s.chunkChecksum
+		if delimSuffix {
+			checksum = crc32.Update(checksum, crc32.IEEETable, s.outputDelim)
+		}
+	} else if s.checksumFunc != nil && s.stream == nil {
+		data := s.chunkBuffer.Bytes()
+		if !delimSuffix {
+			data = data[:len(data)-len(s.outputDelim)]
+		}
+		checksum = s.checksumFunc(data)
+	}
+
+	chunkSn := s.chunkSn
+	s.chunkSn++
+	args := &FlushChunkArgs{
+		ChunkSn:      chunkSn,
+		StartValueSn: s.chunkStartValueSn,
+		EndValueSn:   s.chunkEndValueSn,
+		ValueCount:   s.chunkValueNum,
+		ChunkData:    s.chunkBuffer.Bytes(),
+		ScanByteNum:  scanByteNum,
+		StartOffset:  s.chunkStartOffset,
+		EndOffset:    s.chunkEndOffset,
+		IsLastChunk:  isLast,
+		IsStopped:    reason == FlushReasonStopped,
+		FlushReason:  reason,
+		Checksum:     checksum,
+		Partition:    s.partition,
+		SizeExceeded: s.chunkSizeExceeded,
+		DelimSuffix:  delimSuffix,
+	}
+	var err error
+	if s.stream != nil {
+		err = s.closeChunkStream(args)
+	} else {
+		err = s.flushChunk(args)
+	}
+	s.chunkBuffer.Reset()
+	s.chunkStartValueSn = s.nextValueSn
+	s.chunkValueNum = 0
+	s.chunkSizeExceeded = false
+	s.chunkChecksum = 0
+	s.chunkValueEnds = s.chunkValueEnds[:0]
+	s.chunkRawValues = s.chunkRawValues[:0]
+	s.chunkDelims = s.chunkDelims[:0]
+	s.chunkDelimEnds = s.chunkDelimEnds[:0]
+	return err
+}
+
+func (s *splitter) flushChunk(args *FlushChunkArgs) error {
 	// 这里目的是为了去掉chunk中最后的分隔符
-	src := args.ChunkData[:len(args.ChunkData)-len(s.delimiter)]
+	src := args.ChunkData
+	if !args.DelimSuffix {
+		src = src[:len(src)-len(s.outputDelim)]
+	}
 
-	// 创建副本
-	bs := make([]byte, len(src))
-	copy(bs, src)
+	// 创建副本, 异步 flush 时 handler 返回前缓冲区可能已被重用, 所以总是需要复制
+	bs := src
+	size := len(src)
+	switch {
+	case s.needSpill(src):
+		n, err := s.spillChunk(args, src)
+		if err != nil {
+			return err
+		}
+		bs = nil
+		size = n
+		s.shrinkChunkBuffer()
+	case s.disableChunkCopy && s.pool == nil && s.chunkCh == nil && !s.holdsChunk():
+	case s.poolChunkData:
+		args.pooledData = getPooledChunkData(src)
+		bs = *args.pooledData
+	default:
+		bs = make([]byte, len(src))
+		copy(bs, src)
+	}
 
 	args.ChunkData = bs
-	s.flushChunkHandler(args)
+	s.stats.ChunkByteNum += int64(size)
+	if s.includeValues {
+		args.Values = s.chunkValues(bs)
+		if s.delimMatch != nil {
+			args.ValueDelims = s.chunkValueDelims()
+		}
+		if s.omitChunkData {
+			args.ChunkData = nil
+		}
+	}
+	if s.holdsChunk() {
+		return s.holdChunk(args)
+	}
+	return s.dispatchChunk(args)
+}
+
+// 将 chunk 发送到 chunk chan, 工作池或者 handler
+func (s *splitter) dispatchChunk(args *FlushChunkArgs) error {
+	s.stampChunkID(args)
+	if s.chunkFilter != nil {
+		keep, err := s.callChunkFilter(args)
+		if err != nil {
+			args.releaseSpill()
+			return err
+		}
+		if !keep {
+			s.skipChunk(args)
+			return nil
+		}
+	}
+
+	s.decorateChunk(args)
+	if err := s.compressChunk(args); err != nil {
+		return err
+	}
+	s.encodeChunk(args)
+	if err := s.transformChunk(args); err != nil {
+		return err
+	}
+	if s.chunkCh != nil {
+		// StopAndFlush 时 ctx 已被取消, 此时需要保证剩余数据被发送
+		if args.IsStopped {
+			s.chunkCh <- args
+			return nil
+		}
+		select {
+		case s.chunkCh <- args:
+			return nil
+		case <-s.ctx.Done():
+			args.releaseSpill()
+			return context.Cause(s.ctx)
+		}
+	}
+	if s.pool != nil {
+		s.pool.submit(args)
+		return nil
+	}
+	err := s.flushWithRetry(args)
+	if err == nil && s.orderedFlushHandler != nil {
+		err = s.callOrderedFlushHandler(args)
+	}
+	args.releaseSpill()
+	return err
+}
+
+// 跳过被 chunkFilter 过滤的 chunk, 它仍然占用 ChunkSn
+func (s *splitter) skipChunk(args *FlushChunkArgs) {
+	s.stats.SkippedChunkNum++
+	args.Release()
+	args.releaseSpill()
+	if s.pool != nil {
+		s.pool.skip(args.ChunkSn)
+	}
+}
+
+// 按记录的 value 结束位置从 chunk 数据中切分出每个 value, 每个 value 的容量被限制为其长度, 避免 append 时覆盖后面的数据.
+// JSON 数组格式时从编码前的 value 的副本中切分
+func (s *splitter) chunkValues(data []byte) [][]byte {
+	if s.chunkFormat == ChunkFormatJSONArray {
+		data = bytes.Clone(s.chunkRawValues)
+	}
+	values := make([][]byte, len(s.chunkValueEnds))
+	start := 0
+	for i, end := range s.chunkValueEnds {
+		values[i] = data[start:end:end]
+		start = end + len(s.outputDelim)
+	}
+	return values
+}
+
+// 复制 chunk 中每个 value 结束的分隔符, 没有以分隔符结束的 value 为 nil
+func (s *splitter) chunkValueDelims() [][]byte {
+	buf := bytes.Clone(s.chunkDelims)
+	delims := make([][]byte, len(s.chunkDelimEnds))
+	start := 0
+	for i, end := range s.chunkDelimEnds {
+		if end > start {
+			delims[i] = buf[start:end:end]
+		}
+		start = end
+	}
+	return delims
+}
+
+// 如果已暂停则阻塞等待恢复, 停止或者 ctx 取消
+func (s *splitter) waitResume(ctx context.Context) {
+	s.pauseMu.Lock()
+	ch, stopCh := s.resumeCh, s.stopChan()
+	s.pauseMu.Unlock()
+	if ch == nil {
+		return
+	}
+
+	select {
+	case <-ch:
+	case <-stopCh:
+	case <-ctx.Done():
+	}
+}
+
+// 返回 Stop 时关闭的 chan, 调用者需要持有 pauseMu
+func (s *splitter) stopChan() chan struct{} {
+	if s.stopCh == nil {
+		s.stopCh = make(chan struct{})
+	}
+	return s.stopCh
+}
+
+func (s *splitter) Pause() {
+	s.pauseMu.Lock()
+	if s.resumeCh == nil {
+		s.resumeCh = make(chan struct{})
+	}
+	s.pauseMu.Unlock()
+}
+
+func (s *splitter) Resume() {
+	s.pauseMu.Lock()
+	if s.resumeCh != nil {
+		close(s.resumeCh)
+		s.resumeCh = nil
+	}
+	s.pauseMu.Unlock()
+}
+
+func (s *splitter) SetRateLimit(rateLimit int) {
+	s.rateLimit.Store(int64(rateLimit))
+	if vr := s.vr.Load(); vr != nil {
+		vr.SetRateLimit(rateLimit)
+	}
+}
+
+func (s *splitter) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *splitter) LastError() error {
+	select {
+	case <-s.done:
+		return s.lastErr
+	default:
+		return nil
+	}
+}
+
+func (s *splitter) Reset() error {
+	// 结束回调执行完且 done 关闭后才算运行结束
+	if atomic.LoadInt32(&s.started) > 0 {
+		select {
+		case <-s.done:
+		default:
+			return ErrSplitterIsRunning
+		}
+	}
+
+	s.resetChunkStates()
+	s.chunkSn = 0
+	s.nextValueSn = 0
+	s.skippedValueNum = 0
+	s.stats = Stats{}
+	s.vr.Store(nil)
+	s.lastErr = nil
+	s.done = make(chan struct{})
+	s.cancel.Store(nil)
+	s.ctx = nil
+	s.chunkCh = nil
+	if s.chunkChan != nil {
+		s.chunkChan = make(chan *FlushChunkArgs, cap(s.chunkChan))
+	}
+	if s.dedup != nil {
+		s.dedup.reset()
+	}
+	s.Resume()
+	s.pauseMu.Lock()
+	s.stopCh = nil
+	s.pauseMu.Unlock()
+
+	atomic.StoreInt32(&s.stopFlush, 0)
+	atomic.StoreInt32(&s.stopped, 0)
+	atomic.StoreInt32(&s.finished, 0)
+	atomic.StoreInt32(&s.started, 0)
+	return nil
+}
+
+func (s *splitter) StopAndFlush() {
+	atomic.AddInt32(&s.stopFlush, 1)
+	s.Stop()
 }
 
 func (s *splitter) Stop() {
 	atomic.AddInt32(&s.stopped, 1)
+	if cancel := s.cancel.Load(); cancel != nil {
+		(*cancel)(ErrSplitterIsStopped)
+	}
+
+	s.pauseMu.Lock()
+	stopCh := s.stopChan()
+	select {
+	case <-stopCh:
+	default:
+		close(stopCh)
+	}
+	s.pauseMu.Unlock()
 }
 
-func defaultFlushChunkHandler(args *FlushChunkArgs) {
-	fmt.Println(args.ChunkSn, args.StartValueSn, args.EndValueSn, string(args.ChunkData))
+// 传给 FlushChunkCtxHandler 的 ctx, StopAndFlush 时 ctx 已被取消, 此时需要保证剩余数据被处理, 所以去掉取消
+func (s *splitter) flushContext(args *FlushChunkArgs) context.Context {
+	if args.IsStopped {
+		return context.WithoutCancel(s.ctx)
+	}
+	return s.ctx
+}
+
+// handler 因为 Stop 取消了 ctx 而返回 ctx 的错误时, 和读取时被停止一样返回 ErrSplitterIsStopped
+func (s *splitter) ctxHandlerErr(ctx context.Context, err error) error {
+	if err != nil && atomic.LoadInt32(&s.stopped) > 0 && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return ErrSplitterIsStopped
+	}
+	return err
 }
@@ -0,0 +1,383 @@
+package splitter
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValueCountWithFilter(t *testing.T) {
+	type chunk struct {
+		data       string
+		valueCount int
+		start, end int64
+	}
+	cases := []struct {
+		name  string
+		conf  Conf
+		input string
+		want  []chunk
+	}{
+		{"drop middle", Conf{ChunkValueCountLimit: 3}, "a\nx\nb\nc\nx\nx\nd\ne",
+			[]chunk{{"a\nb\nc", 3, 0, 2}, {"d\ne", 2, 3, 4}}},
+		{"drop middle count filtered", Conf{ChunkValueCountLimit: 3, CountFilteredValues: true}, "a\nx\nb\nc\nx\nx\nd\ne",
+			[]chunk{{"a\nb\nc", 3, 0, 3}, {"d\ne", 2, 6, 7}}},
+		{"drop all but edges", Conf{}, "a\nx\nx\nx\nb",
+			[]chunk{{"a\nb", 2, 0, 1}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []chunk
+			conf := c.conf
+			conf.Delim = []byte("\n")
+			conf.ChunkSizeLimit = 1024
+			conf.ValueFilter = func(value []byte) []byte {
+				if bytes.Equal(value, []byte("x")) {
+					return nil
+				}
+				return value
+			}
+			conf.FlushChunkHandler = func(args *FlushChunkArgs) error {
+				got = append(got, chunk{string(args.ChunkData), args.ValueCount, args.StartValueSn, args.EndValueSn})
+				// ValueCount 和实际拼接的 value 数一致
+				if n := bytes.Count(args.ChunkData, conf.Delim) + 1; n != args.ValueCount {
+					t.Errorf("chunk %d: ValueCount %d, data has %d values", args.ChunkSn, args.ValueCount, n)
+				}
+				return nil
+			}
+			if err := NewSplitter(conf).RunSplit(strings.NewReader(c.input)); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+			for i := range c.want {
+				if got[i] != c.want[i] {
+					t.Errorf("chunk %d = %+v, want %+v", i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMaxChunkInterval(t *testing.T) {
+	type chunk struct {
+		data   string
+		reason FlushReason
+	}
+	const interval = 20 * time.Millisecond
+	cases := []struct {
+		name string
+		feed func(t *testing.T, s Splitter, w io.Writer)
+		want func(t *testing.T, chunks []chunk)
+	}{
+		{"eof before interval", func(t *testing.T, s Splitter, w io.Writer) {
+			_, _ = w.Write([]byte("a\nb"))
+		}, func(t *testing.T, chunks []chunk) {
+			if len(chunks) != 1 || chunks[0] != (chunk{"a\nb", FlushReasonEOF}) {
+				t.Fatalf("got %+v", chunks)
+			}
+		}},
+		{"empty buffer", func(t *testing.T, s Splitter, w io.Writer) {
+			_, _ = w.Write([]byte("a\n"))
+			waitScanned(t, s, 2)
+			// 超过多个间隔都没有新的数据, 不会 flush 空的 chunk, EOF 时也不会
+			time.Sleep(interval * 6)
+		}, func(t *testing.T, chunks []chunk) {
+			if len(chunks) != 1 || chunks[0] != (chunk{"a", FlushReasonAgeLimit}) {
+				t.Fatalf("got %+v", chunks)
+			}
+		}},
+		{"eof flushes remainder", func(t *testing.T, s Splitter, w io.Writer) {
+			_, _ = w.Write([]byte("a\n"))
+			waitScanned(t, s, 2)
+			time.Sleep(interval * 3)
+			_, _ = w.Write([]byte("b\nc"))
+		}, func(t *testing.T, chunks []chunk) {
+			if len(chunks) != 2 || chunks[0] != (chunk{"a", FlushReasonAgeLimit}) || chunks[1] != (chunk{"b\nc", FlushReasonEOF}) {
+				t.Fatalf("got %+v", chunks)
+			}
+		}},
+		{"continuous writes", func(t *testing.T, s Splitter, w io.Writer) {
+			for range 50 {
+				_, _ = w.Write([]byte("v\n"))
+				time.Sleep(2 * time.Millisecond)
+			}
+		}, func(t *testing.T, chunks []chunk) {
+			// 数据一直在写入时也会按间隔 flush
+			if len(chunks) < 2 {
+				t.Fatalf("got %d chunks, want at least 2", len(chunks))
+			}
+			var n int
+			for i, c := range chunks {
+				if c.data == "" || c.reason != FlushReasonAgeLimit && !(i == len(chunks)-1 && c.reason == FlushReasonEOF) {
+					t.Fatalf("got chunk %+v", c)
+				}
+				n += strings.Count(c.data, "v")
+			}
+			if n != 50 {
+				t.Fatalf("got %d values, want 50", n)
+			}
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var chunks []chunk
+			s := NewSplitter(Conf{
+				Delim:            []byte("\n"),
+				ChunkSizeLimit:   1024,
+				MaxChunkInterval: interval,
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					chunks = append(chunks, chunk{string(args.ChunkData), args.FlushReason})
+					return nil
+				},
+			})
+			pr, pw := io.Pipe()
+			runErr := make(chan error, 1)
+			go func() { runErr <- s.RunSplit(pr) }()
+			c.feed(t, s, pw)
+			pw.Close()
+			if err := <-runErr; err != nil {
+				t.Fatal(err)
+			}
+			c.want(t, chunks)
+		})
+	}
+}
+
+func TestMaxChunkCount(t *testing.T) {
+	type chunk struct {
+		data   string
+		isLast bool
+	}
+	cases := []struct {
+		name  string
+		input string
+		max   int
+		merge bool
+		want  []chunk
+	}{
+		{"discard remainder", "a\nb\nc\nd\ne", 2, false, []chunk{{"a\nb", false}, {"c\nd", true}}},
+		{"merge remainder", "a\nb\nc\nd\ne", 2, true, []chunk{{"a\nb", false}, {"c\nd\ne", true}}},
+		{"exact count", "a\nb\nc\nd\ne\nf", 3, false, []chunk{{"a\nb", false}, {"c\nd", false}, {"e\nf", true}}},
+		{"exact count merge", "a\nb\nc\nd\ne\nf", 3, true, []chunk{{"a\nb", false}, {"c\nd", false}, {"e\nf", true}}},
+		{"fewer chunks", "a\nb\nc", 3, false, []chunk{{"a\nb", false}, {"c", true}}},
+		{"single chunk merge", "a\nb\nc\nd\ne", 1, true, []chunk{{"a\nb\nc\nd\ne", true}}},
+		{"single chunk", "a\nb\nc\nd\ne", 1, false, []chunk{{"a\nb", true}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []chunk
+			s := NewSplitter(Conf{
+				Delim:                []byte("\n"),
+				ChunkSizeLimit:       1024,
+				ChunkValueCountLimit: 2,
+				MaxChunkCount:        c.max,
+				MergeRemainder:       c.merge,
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					got = append(got, chunk{string(args.ChunkData), args.IsLastChunk})
+					return nil
+				},
+			})
+			if err := s.RunSplit(strings.NewReader(c.input)); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+			for i := range c.want {
+				if got[i] != c.want[i] {
+					t.Errorf("chunk %d = %+v, want %+v", i, got[i], c.want[i])
+				}
+			}
+			if s.Stats().ChunkNum != len(c.want) {
+				t.Errorf("ChunkNum = %d, want %d", s.Stats().ChunkNum, len(c.want))
+			}
+		})
+	}
+}
+
+func TestChunkSizeLimitExactPacking(t *testing.T) {
+	cases := []struct {
+		name        string
+		delim       string
+		keepTrailer bool
+		values      []string
+		want        []string
+	}{
+		{"exact fit", "\n", false, []string{"aaaaaaa", "bbbbbbbb", "c"}, []string{"aaaaaaa\nbbbbbbbb", "c"}},
+		{"one byte over", "\n", false, []string{"aaaaaaa", "bbbbbbbbb", "c"}, []string{"aaaaaaa", "bbbbbbbbb\nc"}},
+		{"long delim exact fit", "\r\n---\r\n", false, []string{"aaaa", "bbbbb"}, []string{"aaaa\r\n---\r\nbbbbb"}},
+		{"long delim one byte over", "\r\n---\r\n", false, []string{"aaaa", "bbbbbb"}, []string{"aaaa", "bbbbbb"}},
+		{"keep trailing delim exact fit", "\n", true, []string{"aaaaaaa", "bbbbbbb", "c"}, []string{"aaaaaaa\nbbbbbbb\n", "c"}},
+		{"keep trailing delim one byte over", "\n", true, []string{"aaaaaaa", "bbbbbbbb", "c"}, []string{"aaaaaaa\n", "bbbbbbbb\nc"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []string
+			s := NewSplitter(Conf{
+				Delim:             []byte(c.delim),
+				ChunkSizeLimit:    MinChunkSizeLimit,
+				KeepTrailingDelim: c.keepTrailer,
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					if len(args.ChunkData) > MinChunkSizeLimit {
+						t.Errorf("chunk %q exceeds %d bytes", args.ChunkData, MinChunkSizeLimit)
+					}
+					got = append(got, string(args.ChunkData))
+					return nil
+				},
+			})
+			if err := s.RunSplit(strings.NewReader(strings.Join(c.values, c.delim))); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(c.want, "|") {
+				t.Fatalf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+// 每个 chunk 都不超过限制, 并且加入下一个 value 就会超过限制
+func TestChunkSizeLimitMaximalPacking(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, delim := range []string{"\n", "\r\n---\r\n"} {
+		for _, limit := range []int{16, 17, 40} {
+			values := make([]string, 200)
+			for i := range values {
+				values[i] = strings.Repeat("x", 1+rnd.Intn(limit/2))
+			}
+			var chunks []string
+			s := NewSplitter(Conf{
+				Delim:          []byte(delim),
+				ChunkSizeLimit: limit,
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					chunks = append(chunks, string(args.ChunkData))
+					return nil
+				},
+			})
+			if err := s.RunSplit(strings.NewReader(strings.Join(values, delim))); err != nil {
+				t.Fatal(err)
+			}
+			next := 0
+			for i, chunk := range chunks {
+				if len(chunk) > limit {
+					t.Fatalf("delim %q, limit %d: chunk %d has %d bytes", delim, limit, i, len(chunk))
+				}
+				next += strings.Count(chunk, delim) + 1
+				if next < len(values) && len(chunk)+len(delim)+len(values[next]) <= limit {
+					t.Fatalf("delim %q, limit %d: chunk %d (%d bytes) could fit value of %d bytes", delim, limit, i, len(chunk), len(values[next]))
+				}
+			}
+			if next != len(values) {
+				t.Fatalf("delim %q, limit %d: got %d values, want %d", delim, limit, next, len(values))
+			}
+		}
+	}
+}
+
+func TestMinChunkValueCountOversizedValues(t *testing.T) {
+	type chunk struct {
+		data     string
+		exceeded bool
+	}
+	big := strings.Repeat("x", 40)
+	cases := []struct {
+		name       string
+		minCount   int
+		valueLimit int
+		values     []string
+		want       []chunk
+	}{
+		{"disabled", 0, 0, []string{big, "a", big, "b"}, []chunk{{big, false}, {"a", false}, {big, false}, {"b", false}}},
+		{"oversized first value", 2, 0, []string{big, "a", "b"}, []chunk{{big + "\na", true}, {"b", false}}},
+		{"oversized second value", 2, 0, []string{"a", big, "b"}, []chunk{{"a\n" + big, true}, {"b", false}}},
+		{"all oversized", 2, 0, []string{big, big, big, big, big}, []chunk{{big + "\n" + big, true}, {big + "\n" + big, true}, {big, false}}},
+		{"small values not exceeded", 2, 0, []string{"aaaaaaa", "bbbbbbb", "c"}, []chunk{{"aaaaaaa\nbbbbbbb", false}, {"c", false}}},
+		{"value count limit still applies", 3, 2, []string{big, big, big}, []chunk{{big + "\n" + big, true}, {big, false}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var got []chunk
+			s := NewSplitter(Conf{
+				Delim:                []byte("\n"),
+				ChunkSizeLimit:       MinChunkSizeLimit,
+				MinChunkValueCount:   c.minCount,
+				ChunkValueCountLimit: c.valueLimit,
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					got = append(got, chunk{string(args.ChunkData), args.SizeExceeded})
+					return nil
+				},
+			})
+			if err := s.RunSplit(strings.NewReader(strings.Join(c.values, "\n"))); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+			for i := range c.want {
+				if got[i] != c.want[i] {
+					t.Errorf("chunk %d = %+v, want %+v", i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDiscardedValueNumCountsEmptyValues(t *testing.T) {
+	// 空 value 和 TrimCR 后为空的行都会计入 DiscardedValueNum, 和 CountFilteredValues 占用的 sn 一致.
+	// rd 以分隔符结尾时最后的空 value 不是 rd 中的 value, 既不计入 ScanValueNum 也不计入 DiscardedValueNum
+	cases := []struct {
+		name, delim, input string
+		data               string
+		valueNum, discard  int64
+	}{
+		{"lf", "\n", "a\n\nb\r\n\r\n\nc", "a\nb\nc", 3, 3},
+		{"trailing delim", ",", "a,,b,", "a,b", 2, 1},
+		{"trailing crlf", "\n", "a\r\n\r\nb\r\n", "a\nb", 2, 1},
+		{"crlf delim", "\r\n", "a\r\n\r\nb\r\n", "a\r\nb", 2, 1},
+		{"only delims", ",", ",,,", "", 0, 3},
+	}
+	for _, c := range cases {
+		for _, countFiltered := range []bool{false, true} {
+			var start, end int64
+			var data string
+			s := NewSplitter(Conf{
+				Delim:               []byte(c.delim),
+				ChunkSizeLimit:      1024,
+				TrimCR:              true,
+				CountFilteredValues: countFiltered,
+				FlushChunkHandler: func(args *FlushChunkArgs) error {
+					start, end, data = args.StartValueSn, args.EndValueSn, string(args.ChunkData)
+					return nil
+				},
+			})
+			if err := s.RunSplit(strings.NewReader(c.input)); err != nil {
+				t.Fatal(err)
+			}
+			if data != c.data {
+				t.Errorf("%s countFiltered=%v: data %q, want %q", c.name, countFiltered, data, c.data)
+			}
+			st := s.Stats()
+			if st.ValueNum != c.valueNum || st.DiscardedValueNum != c.discard {
+				t.Errorf("%s countFiltered=%v: ValueNum %d, DiscardedValueNum %d, want %d, %d", c.name, countFiltered, st.ValueNum, st.DiscardedValueNum, c.valueNum, c.discard)
+			}
+			if st.ScanValueNum != st.ValueNum+st.DiscardedValueNum {
+				t.Errorf("%s countFiltered=%v: ScanValueNum %d != ValueNum %d + DiscardedValueNum %d", c.name, countFiltered, st.ScanValueNum, st.ValueNum, st.DiscardedValueNum)
+			}
+			if c.valueNum == 0 {
+				continue
+			}
+			// 这些输入的最后一个 value 都会保留
+			wantEnd := c.valueNum - 1
+			if countFiltered {
+				wantEnd = st.ScanValueNum - 1
+			}
+			if start != 0 || end != wantEnd {
+				t.Errorf("%s countFiltered=%v: sn %d-%d, want 0-%d", c.name, countFiltered, start, end, wantEnd)
+			}
+		}
+	}
+}
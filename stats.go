@@ -0,0 +1,72 @@
+package splitter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 运行统计
+type Stats struct {
+	ChunkNum          int   // 已 flush 的 chunk 数, 包括被 ChunkFilter 跳过的 chunk
+	SkippedChunkNum   int   // 被 ChunkFilter 跳过的 chunk 数
+	ChunkByteNum      int64 // 已 flush 的 chunk 数据总字节数
+	ScanValueNum      int64 // 从rd读取的 value 数, 包括空 value 和被丢弃的 value, 不包括 rd 以分隔符结尾时最后的空 value
+	ValueNum          int64 // 写入 chunk 的 value 数
+	DiscardedValueNum int64 // 被丢弃的 value 数, 包括空 value, TrimCR, TrimSpace 或 ValuePrefixTrim 后为空, 以及被 ValueFilter, 去重或 DropUnprefixedValues 丢弃的 value
+	MaxValueSize      int   // 读取到的最大 value 长度(过滤前)
+	ScanByteNum       int64 // 已扫描rd的字节数
+	PendingChunkPeak  int   // 并发 flush 时积压的 chunk 数峰值
+	PendingBytePeak   int64 // 并发 flush 时积压的 chunk 数据字节数峰值
+}
+
+// 一次运行的汇总, 传给 OnSummary. 只包含可以直接序列化的字段
+type RunSummary struct {
+	Stats                   // 运行结束时的统计
+	StartTime time.Time     // 开始运行的时间
+	Duration  time.Duration // 运行耗时
+	Stopped   bool          // 是否因为 Stop 或 StopAndFlush 结束
+	Error     string        // RunSplit 返回的错误信息, 成功时为空
+}
+
+// 生成本次运行的汇总
+func (s *splitter) runSummary(err error) RunSummary {
+	summary := RunSummary{
+		Stats:     s.stats,
+		StartTime: s.startTime,
+		Duration:  time.Since(s.startTime),
+		Stopped:   atomic.LoadInt32(&s.stopped) > 0,
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+// 获取运行统计, 在 RunSplit 返回前调用会返回零值
+func (s *splitter) Stats() Stats {
+	if atomic.LoadInt32(&s.finished) == 0 {
+		return Stats{}
+	}
+	return s.stats
+}
+
+// 获取当前已扫描rd的字节数, 未开始运行时返回 0
+func (s *splitter) ScanByteNum() int64 {
+	vr := s.vr.Load()
+	if vr == nil {
+		return 0
+	}
+	return vr.GetScanByteNum()
+}
+
+// 获取并发 flush 时当前积压的 chunk 数和数据字节数
+func (s *splitter) PendingChunks() (chunkNum int, byteNum int64) {
+	return int(s.pendingChunkNum.Load()), s.pendingByteNum.Load()
+}
+
+// 运行结束时记录统计
+func (s *splitter) finishStats(scanByteNum int64) {
+	s.stats.ChunkNum = s.chunkSn
+	s.stats.ScanByteNum = scanByteNum
+	atomic.StoreInt32(&s.finished, 1)
+}
@@ -0,0 +1,95 @@
+package splitter
+
+import (
+	"io"
+)
+
+// 流式 flush 时正在写入的 chunk
+type chunkStream struct {
+	args *FlushChunkArgs
+	pw   *io.PipeWriter
+	done chan error // handler 返回后发送结果
+	n    int        // 已写入的字节数
+}
+
+// 是否使用流式 flush, RunSplitChan 时不使用
+func (s *splitter) isStreaming() bool {
+	return s.flushChunkStreamHandler != nil && s.chunkCh == nil
+}
+
+// 将 chunk 缓冲区中除末尾分隔符外的数据写入流, chunk 的第一个 value 写入时会启动 handler.
+// 末尾的分隔符会保留到写入下一个 value 时, 因为它可能是 chunk 的最后一个分隔符
+func (s *splitter) writeChunkStream() error {
+	if s.stream == nil {
+		s.stream = s.startChunkStream()
+	}
+
+	data := s.chunkBuffer.Bytes()
+	data = data[:len(data)-len(s.outputDelim)]
+	n, err := s.stream.pw.Write(data)
+	s.stream.n += n
+	s.chunkBuffer.Next(len(data))
+	return err
+}
+
+func (s *splitter) startChunkStream() *chunkStream {
+	pr, pw := io.Pipe()
+	st := &chunkStream{
+		args: &FlushChunkArgs{
+			ChunkSn:      s.chunkSn,
+			StartValueSn: s.chunkStartValueSn,
+			StartOffset:  s.chunkStartOffset,
+		},
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	s.stampChunkID(st.args)
+	go func() {
+		err := s.callFlushChunkStreamHandler(st.args, pr)
+		if err != nil {
+			// 让后续的写入立即返回这个错误
+			_ = pr.CloseWithError(err)
+		} else {
+			// handler 没有读取完时丢弃剩余的数据
+			_, _ = io.Copy(io.Discard, pr)
+		}
+		st.done <- err
+	}()
+	return st
+}
+
+// 结束当前 chunk 的流并等待 handler 返回, args 中的字段会在 handler 读取到 io.EOF 前设置
+func (s *splitter) closeChunkStream(args *FlushChunkArgs) error {
+	st := s.stream
+	s.stream = nil
+
+	a := st.args
+	a.EndValueSn = args.EndValueSn
+	a.ValueCount = args.ValueCount
+	a.ScanByteNum = args.ScanByteNum
+	a.EndOffset = args.EndOffset
+	a.IsLastChunk = args.IsLastChunk
+	a.IsStopped = args.IsStopped
+	a.FlushReason = args.FlushReason
+	a.Checksum = args.Checksum
+	a.DelimSuffix = args.DelimSuffix
+	if args.DelimSuffix {
+		// 写入失败时 handler 已经返回了错误
+		n, _ := st.pw.Write(s.outputDelim)
+		st.n += n
+	}
+	s.stats.ChunkByteNum += int64(st.n)
+
+	_ = st.pw.Close()
+	return <-st.done
+}
+
+// 运行出错结束时中断当前 chunk 的流, handler 读取时会收到 err
+func (s *splitter) abortChunkStream(err error) {
+	if s.stream == nil {
+		return
+	}
+	_ = s.stream.pw.CloseWithError(err)
+	<-s.stream.done
+	s.stream = nil
+}
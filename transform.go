@@ -0,0 +1,68 @@
+package splitter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrChunkTransform = errors.New("chunk transform err")
+
+// 依次调用 chunkTransformers 转换 ChunkData, 相邻的转换器交替使用两个内部缓冲区, 最后的结果会复制到新的缓冲区
+func (s *splitter) transformChunk(args *FlushChunkArgs) error {
+	if len(s.chunkTransformers) == 0 || s.omitChunkData {
+		return nil
+	}
+
+	data := args.ChunkData
+	for i, transformer := range s.chunkTransformers {
+		dst := &s.transformBuffers[i%2]
+		dst.Reset()
+		if err := transformer(dst, args, data); err != nil {
+			return fmt.Errorf("%w: chunkSn=%d, transformer=%d: %w", ErrChunkTransform, args.ChunkSn, i, err)
+		}
+		data = dst.Bytes()
+	}
+	args.ChunkData = append([]byte(nil), data...)
+	return nil
+}
+
+// 返回一个使用 gzip 压缩 chunk 的转换器, level 为 0 时使用 gzip.DefaultCompression, 不合法时会 panic. 可以在多个分隔器中共用
+func GzipChunkTransformer(level int) ChunkTransformer {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		panic(err)
+	}
+
+	pool := &sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	}}
+	return func(dst *bytes.Buffer, args *FlushChunkArgs, data []byte) error {
+		w := pool.Get().(*gzip.Writer)
+		defer pool.Put(w)
+
+		w.Reset(dst)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+}
+
+// 返回一个使用 base64 编码 chunk 的转换器, enc 为 nil 时使用 base64.StdEncoding
+func Base64ChunkTransformer(enc *base64.Encoding) ChunkTransformer {
+	if enc == nil {
+		enc = base64.StdEncoding
+	}
+	return func(dst *bytes.Buffer, args *FlushChunkArgs, data []byte) error {
+		dst.Grow(enc.EncodedLen(len(data)))
+		_, err := dst.Write(enc.AppendEncode(dst.AvailableBuffer(), data))
+		return err
+	}
+}
@@ -0,0 +1,123 @@
+package splitter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestChunkTransformers(t *testing.T) {
+	input := numberedInput(300)
+	base := Conf{Delim: []byte("\n"), ChunkSizeLimit: 200}
+	want := mustCollectChunks(t, base, input)
+
+	upper := func(dst *bytes.Buffer, args *FlushChunkArgs, data []byte) error {
+		dst.Write(bytes.ToUpper(data))
+		return nil
+	}
+	double := func(dst *bytes.Buffer, args *FlushChunkArgs, data []byte) error {
+		dst.Write(data)
+		dst.Write(data)
+		return nil
+	}
+	cases := []struct {
+		name         string
+		transformers []ChunkTransformer
+		decode       func(t *testing.T, data []byte) []byte
+		expect       func(plain []byte) []byte
+	}{
+		{"gzip", []ChunkTransformer{GzipChunkTransformer(0)}, gunzip, nil},
+		{"gzip best speed", []ChunkTransformer{GzipChunkTransformer(gzip.BestSpeed)}, gunzip, nil},
+		{"base64", []ChunkTransformer{Base64ChunkTransformer(nil)}, func(t *testing.T, data []byte) []byte {
+			out, err := base64.StdEncoding.DecodeString(string(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return out
+		}, nil},
+		{"gzip then base64 url", []ChunkTransformer{GzipChunkTransformer(0), Base64ChunkTransformer(base64.URLEncoding)}, func(t *testing.T, data []byte) []byte {
+			out, err := base64.URLEncoding.DecodeString(string(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return gunzip(t, out)
+		}, nil},
+		// 多个转换器交替使用内部缓冲区, 每一步的输入不会被输出覆盖
+		{"three stages", []ChunkTransformer{double, upper, double}, nil, func(plain []byte) []byte {
+			d := bytes.ToUpper(append(append([]byte(nil), plain...), plain...))
+			return append(append([]byte(nil), d...), d...)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := base
+			conf.ChunkTransformers = c.transformers
+			got := mustCollectChunks(t, conf, input)
+			if len(got) != len(want) {
+				t.Fatalf("got %d chunks, want %d", len(got), len(want))
+			}
+			for sn, w := range want {
+				data := got[sn].ChunkData
+				expected := w.ChunkData
+				if c.decode != nil {
+					data = c.decode(t, data)
+				}
+				if c.expect != nil {
+					expected = c.expect(w.ChunkData)
+				}
+				if !bytes.Equal(data, expected) {
+					t.Fatalf("chunk %d: got %q, want %q", sn, data, expected)
+				}
+				if got[sn].Checksum != w.Checksum {
+					t.Errorf("chunk %d: checksum differs from untransformed run", sn)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkTransformerError(t *testing.T) {
+	errTransform := errors.New("transform failed")
+	var handled []int
+	s := NewSplitter(Conf{
+		Delim:                []byte("\n"),
+		ChunkSizeLimit:       1024,
+		ChunkValueCountLimit: 1,
+		ChunkTransformers: []ChunkTransformer{
+			Base64ChunkTransformer(nil),
+			func(dst *bytes.Buffer, args *FlushChunkArgs, data []byte) error {
+				if args.ChunkSn == 2 {
+					return errTransform
+				}
+				dst.Write(data)
+				return nil
+			},
+		},
+		FlushChunkHandler: func(args *FlushChunkArgs) error {
+			handled = append(handled, args.ChunkSn)
+			return nil
+		},
+	})
+	err := s.RunSplit(strings.NewReader("a\nb\nc\nd\ne"))
+	if !errors.Is(err, ErrChunkTransform) || !errors.Is(err, errTransform) {
+		t.Fatalf("got %v, want ErrChunkTransform wrapping the transformer error", err)
+	}
+	if !strings.Contains(err.Error(), "chunkSn=2") || !strings.Contains(err.Error(), "transformer=1") {
+		t.Fatalf("error %q does not name the chunk and transformer", err)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("handler called for chunks %v, want [0 1]", handled)
+	}
+}
+
+func TestGzipChunkTransformerInvalidLevel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("GzipChunkTransformer did not panic on invalid level")
+		}
+	}()
+	GzipChunkTransformer(100)
+}
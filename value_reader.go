@@ -5,19 +5,34 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
-	"math"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
+var ErrEmptyDelim = errors.New("delim must not be empty")
 var ErrValueReaderMaxScanSizeLimit = errors.New("ValueReader valueMaxScanSizeLimit err")
+var ErrValueReaderUnterminatedQuote = errors.New("ValueReader unterminated quote err")
 
 type ValueReader interface {
 	// 下一个value
 	Next() ([]byte, error)
+	// 查看下一个 value 但不消费它, 下一次 Next 会返回同样的结果. Peek 会读取 rd, 所以之前 Next 返回的数据会失效
+	Peek() ([]byte, error)
 	// 获取已扫描字节数
 	GetScanByteNum() int64
+	// 设置每秒扫描字节数的上限, 爆发量为其十分之一, <=0 表示不限速. 可以在其他 goroutine 中调用
+	SetRateLimit(rateLimit int)
+}
+
+// 开启引号或转义时的扫描状态
+type scanState struct {
+	inQuote      bool // 是否在引号内
+	escaped      bool // 最后一个字节是否为转义字符, 下一个字节会被转义
+	afterLiteral int  // 最后一个被转义的字节及其之后的字节数, 为 0 表示没有被转义的字节
 }
 
 type valueReader struct {
@@ -26,86 +41,329 @@ type valueReader struct {
 	readBuffer []byte
 
 	delim                 []byte
-	valueMaxScanSizeLimit int // 限制value的长度
+	valueMaxScanSizeLimit int // 限制value的长度, 允许扩容时会随 readBuffer 增长
+	valueHardCapLimit     int // 允许扩容时 value 长度的硬上限, 不大于 valueMaxScanSizeLimit 时表示不扩容
 
-	scanByteNum int64 // 已扫描字节数
+	scanByteNum int64 // 已扫描字节数, 可能被其他 goroutine 读取, 需要原子操作
+	valueStart  int64 // 最后一次 Next 返回的 value 在 rd 中的起始偏移
 	isEOF       bool
+	partialLen  int  // 上次 Next 因为读取出错中断时已读取的 value 长度, 下次 Next 会继续读取
+	errLen      int  // 上次 Next 出错时已读取的 value 长度
+	skipping    bool // 是否正在丢弃数据直到下一个分隔符
+
+	peeked    bool   // 是否有 Peek 读取但还没有被 Next 返回的 value
+	peekValue []byte // Peek 读取的 value
+	peekErr   error  // Peek 读取 value 时的错误
+
+	delimMatch   DelimMatchFunc // 分隔符匹配函数, 设置后会忽略 delim
+	matchedDelim []byte         // 设置 delimMatch 时最后一次 Next 返回的 value 结束的分隔符, 没有以分隔符结束时为空
+	peekDelim    []byte         // Peek 读取的 value 结束的分隔符
+
+	quote  byte      // 引号字符, 为 0 表示不启用
+	escape byte      // 转义字符, 为 0 表示不启用
+	scan   scanState // 已读取的数据的引号和转义状态
 
-	limiter *rate.Limiter // 限速器
+	limiter atomic.Pointer[rate.Limiter] // 限速器, 可能被其他 goroutine 修改
+	ctx     context.Context              // 用于取消扫描
 }
 
 func (v *valueReader) GetScanByteNum() int64 {
-	return v.scanByteNum
+	return atomic.LoadInt64(&v.scanByteNum)
 }
 
 // 读取数据直到碰到一个分隔符, 输出数据不包含分隔符. 注意使用者要主动对返回的[]byte进行copy, 否则下次调用此函数会改变它!
+//
+// 这里没有逐字节读取, 而是在 bufio 已缓冲的数据中查找分隔符的最后一个字节, 找到后再校验完整的分隔符.
+// 没有直接使用 ReadSlice 是因为它会消费掉超出 valueMaxScanSizeLimit 的数据, 这里通过 Peek + Discard 精确控制消费的字节数.
+//
+// 每消费到一个 last 字节都会检查是否以完整的分隔符结尾, 所以总是在第一个完整匹配的分隔符处切分, 下一个 value 从这个分隔符之后开始匹配.
+// 对于自身有重叠的分隔符(例如 "aa"), 切分结果和 strings.Split 一致, 例如 "xaaay" 会被切分为 "x" 和 "ay"
 func (v *valueReader) Next() ([]byte, error) {
+	if v.peeked {
+		v.peeked = false
+		v.matchedDelim, v.peekDelim = v.peekDelim, v.matchedDelim
+		value, err := v.peekValue, v.peekErr
+		v.peekValue, v.peekErr = nil, nil
+		return value, err
+	}
+	return v.next()
+}
+
+// 查看下一个 value, 读取的数据会计入已扫描字节数
+func (v *valueReader) Peek() ([]byte, error) {
+	if !v.peeked {
+		// 保留上一次 Next 的分隔符, Peek 读取的分隔符在 Next 返回这个 value 时生效
+		v.matchedDelim, v.peekDelim = v.peekDelim, v.matchedDelim
+		v.peekValue, v.peekErr = v.next()
+		v.matchedDelim, v.peekDelim = v.peekDelim, v.matchedDelim
+		v.peeked = true
+	}
+	return v.peekValue, v.peekErr
+}
+
+func (v *valueReader) next() ([]byte, error) {
+	v.matchedDelim = v.matchedDelim[:0]
 	if v.isEOF {
 		return nil, io.EOF
 	}
+	if v.delimMatch != nil {
+		return v.nextMatch()
+	}
 
-	l := 0
+	l := v.partialLen
+	v.partialLen = 0
+	if l == 0 && !v.skipping {
+		v.valueStart = v.scanByteNum
+	}
 
 	delimLen := len(v.delim)
 	last := v.delim[delimLen-1]
 
+	done := v.ctx.Done()
 	for {
-		// 限速
-		if v.limiter != nil {
-			err := v.limiter.Wait(context.Background())
-			if err != nil {
-				return nil, err
+		// 检查是否已取消
+		if done != nil {
+			select {
+			case <-done:
+				return nil, v.ctx.Err()
+			default:
 			}
 		}
 
-		// 读取1字节
-		b, err := v.reader.ReadByte()
+		// 确保有可读的数据
+		_, err := v.reader.Peek(1)
 		if err == io.EOF {
 			v.isEOF = true
-			return v.readBuffer[:l], nil
+			if v.skipping {
+				v.skipping = false
+				l = 0
+				v.valueStart = v.scanByteNum
+			}
+			inQuote := v.scan.inQuote
+			v.scan = scanState{}
+			if inQuote {
+				return v.readBuffer[:l], ErrValueReaderUnterminatedQuote
+			}
+			return v.unescape(v.readBuffer[:l]), nil
 		}
 		if err != nil {
+			// 保留已读取的数据, 下次调用 Next 时继续读取这个 value
+			v.partialLen = l
+			v.errLen = l
 			return nil, err
 		}
+		data, _ := v.reader.Peek(v.reader.Buffered())
 
-		v.scanByteNum++
-		v.readBuffer[l] = b
-		l++
+		// 本次最多消费到 last 字节处, 且不能超过长度限制. 开启引号或转义时跳过引号内和被转义的 last 字节
+		n := len(data)
+		special := v.quote != 0 || v.escape != 0
+		if special {
+			n, _ = v.scanSpecial(v.scan, data, last)
+		} else if i := bytes.IndexByte(data, last); i >= 0 {
+			n = i + 1
+		}
+		n = min(n, v.valueMaxScanSizeLimit-l)
+
+		// 限速
+		if limiter := v.limiter.Load(); limiter != nil {
+			n, err = v.waitLimiter(limiter, n)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		copy(v.readBuffer[l:], data[:n])
+		if special {
+			_, v.scan = v.scanSpecial(v.scan, data[:n], last)
+		}
+		_, _ = v.reader.Discard(n)
+		atomic.AddInt64(&v.scanByteNum, int64(n))
+		l += n
 		bs := v.readBuffer[:l]
 
-		// 检查是否以 delim 结尾
-		if b == last && l >= delimLen && bytes.Equal(bs[l-delimLen:], v.delim) {
-			return bs[:l-delimLen], nil
+		// 检查是否以 delim 结尾, 在引号内或者第一个字节被转义的 delim 不算
+		if !v.scan.inQuote && v.scan.afterLiteral != delimLen && bs[l-1] == last && l >= delimLen && bytes.Equal(bs[l-delimLen:], v.delim) {
+			if v.skipping {
+				// 丢弃完成, 开始读取下一个 value
+				v.skipping = false
+				l = 0
+				v.valueStart = v.scanByteNum
+				continue
+			}
+			return v.unescape(bs[:l-delimLen]), nil
 		}
 
-		// 检查长度限制
+		// 检查长度限制, 允许扩容时先尝试扩容
 		if l == v.valueMaxScanSizeLimit {
-			return bs, ErrValueReaderMaxScanSizeLimit
+			if v.skipping {
+				l = v.keepTail(l)
+				continue
+			}
+			if !v.grow() {
+				v.errLen = l
+				return bs, ErrValueReaderMaxScanSizeLimit
+			}
 		}
 	}
 }
 
+// 按引号和转义字符的状态扫描 data, 在第一个不在引号内且没有被转义的 last 字节后停止, 返回扫描的长度和扫描后的状态. 没有找到时返回 len(data)
+func (v *valueReader) scanSpecial(st scanState, data []byte, last byte) (int, scanState) {
+	for i, c := range data {
+		if st.afterLiteral > 0 {
+			st.afterLiteral++
+		}
+		switch {
+		case st.escaped:
+			st.escaped = false
+			st.afterLiteral = 1
+		case v.escape != 0 && c == v.escape:
+			st.escaped = true
+		case v.quote != 0 && c == v.quote:
+			st.inQuote = !st.inQuote
+		case c == last && !st.inQuote:
+			return i + 1, st
+		}
+	}
+	return len(data), st
+}
+
+// 去掉 value 中的引号和转义字符, 引号内两个连续的引号还原为一个引号, 转义字符之后的字节按原样保留. 直接在 value 上修改
+func (v *valueReader) unescape(value []byte) []byte {
+	if (v.quote == 0 || bytes.IndexByte(value, v.quote) < 0) && (v.escape == 0 || bytes.IndexByte(value, v.escape) < 0) {
+		return value
+	}
+
+	n := 0
+	inQuote := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case v.escape != 0 && c == v.escape && i+1 < len(value):
+			// 末尾单独的转义字符按原样保留
+			i++
+			c = value[i]
+		case v.quote != 0 && c == v.quote:
+			if !inQuote || i+1 >= len(value) || value[i+1] != v.quote {
+				inQuote = !inQuote
+				continue
+			}
+			// 转义的引号
+			i++
+		}
+		value[n] = c
+		n++
+	}
+	return value[:n]
+}
+
+// 丢弃上次 Next 出错时正在读取的 value, 下次调用 Next 时会先丢弃数据直到碰到分隔符, 然后返回之后的 value
+func (v *valueReader) skipValue() {
+	v.skipping = true
+	v.partialLen = v.keepTail(v.errLen)
+}
+
+// 将 readBuffer[:l] 末尾不足一个分隔符长度的数据移动到开头, 因为分隔符可能跨越已读取的数据. 返回保留的长度
+func (v *valueReader) keepTail(l int) int {
+	keep := min(l, len(v.delim)-1)
+	copy(v.readBuffer, v.readBuffer[l-keep:l])
+	return keep
+}
+
+// 将 readBuffer 扩容为两倍, 不超过 valueHardCapLimit. 已达到上限时返回 false
+func (v *valueReader) grow() bool {
+	if v.valueMaxScanSizeLimit >= v.valueHardCapLimit {
+		return false
+	}
+
+	size := min(v.valueMaxScanSizeLimit*2, v.valueHardCapLimit)
+	buf := make([]byte, size)
+	copy(buf, v.readBuffer)
+	v.readBuffer = buf
+	v.valueMaxScanSizeLimit = size
+	return true
+}
+
+// 等待限速器允许读取最多 n 字节, 返回允许读取的字节数, 不会超过爆发量.
+// 不使用 limiter.Wait, 因为它在预计超过 ctx 截止时间时会提前返回非 ctx 的错误
+func (v *valueReader) waitLimiter(limiter *rate.Limiter, n int) (int, error) {
+	n = min(n, limiter.Burst())
+	r := limiter.ReserveN(time.Now(), n)
+	if !r.OK() {
+		return 0, fmt.Errorf("rate: Wait(n=%d) exceeds limiter's burst", n)
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return n, nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return n, nil
+	case <-v.ctx.Done():
+		r.Cancel()
+		return 0, v.ctx.Err()
+	}
+}
+
+// 设置每秒扫描字节数的上限, 没有限速器时会创建一个
+func (v *valueReader) SetRateLimit(rateLimit int) {
+	if rateLimit <= 0 {
+		v.limiter.Store(nil)
+		return
+	}
+
+	bursts := max(rateLimit/10, 1) // 爆发量为上限的十分之一
+	if limiter := v.limiter.Load(); limiter != nil {
+		limiter.SetLimit(rate.Limit(rateLimit))
+		limiter.SetBurst(bursts)
+		return
+	}
+	v.limiter.Store(rate.NewLimiter(rate.Limit(rateLimit), bursts))
+}
+
 // 创建一个值读取器
 func NewValueReader(rd io.Reader, delim []byte, valueMaxScanSizeLimit int) ValueReader {
 	return NewValueReaderAndLimiter(rd, delim, valueMaxScanSizeLimit, 0)
 }
 
+// 创建一个值读取器, delim 为空时返回 ErrEmptyDelim 而不是 panic
+func NewValueReaderE(rd io.Reader, delim []byte, valueMaxScanSizeLimit int) (ValueReader, error) {
+	if len(delim) == 0 {
+		return nil, ErrEmptyDelim
+	}
+	return NewValueReader(rd, delim, valueMaxScanSizeLimit), nil
+}
+
 // 创建一个值读取器, 限制其读取速率
 func NewValueReaderAndLimiter(rd io.Reader, delim []byte, valueMaxScanSizeLimit int, rateLimit int) ValueReader {
+	return newValueReader(context.Background(), rd, delim, valueMaxScanSizeLimit, rateLimit, 0)
+}
+
+// 创建一个值读取器, 指定从 rd 读取时的缓冲区大小, <=0 时使用 DefaultReadBufferSize
+func NewValueReaderSize(rd io.Reader, delim []byte, valueMaxScanSizeLimit int, readBufferSize int) ValueReader {
+	return newValueReader(context.Background(), rd, delim, valueMaxScanSizeLimit, 0, readBufferSize)
+}
+
+// 创建一个值读取器, 扫描时会检查 ctx 是否已取消
+func newValueReader(ctx context.Context, rd io.Reader, delim []byte, valueMaxScanSizeLimit int, rateLimit int, readBufferSize int) *valueReader {
 	if len(delim) == 0 {
-		panic("delim must not be empty")
+		panic(ErrEmptyDelim)
+	}
+	if readBufferSize <= 0 {
+		readBufferSize = DefaultReadBufferSize
 	}
 
 	bufLen := max(valueMaxScanSizeLimit, MinValueMaxScanSizeLimit)
 	vr := &valueReader{
-		reader:                bufio.NewReader(rd),
+		reader:                bufio.NewReaderSize(rd, max(readBufferSize, MinReadBufferSize)),
 		readBuffer:            make([]byte, bufLen),
 		delim:                 delim,
 		valueMaxScanSizeLimit: bufLen,
+		ctx:                   ctx,
 	}
-	if rateLimit > 0 {
-		bursts := math.Max(float64(int(rateLimit)/10), 1) // 爆发量为上限的十分之一
-		vr.limiter = rate.NewLimiter(rate.Limit(rateLimit), int(bursts))
-	}
+	vr.SetRateLimit(rateLimit)
 	return vr
 }
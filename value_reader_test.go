@@ -0,0 +1,70 @@
+package splitter
+
+import (
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// 读取 rd 中所有的 value
+func readAllValues(t *testing.T, vr ValueReader) []string {
+	t.Helper()
+	var values []string
+	for {
+		value, err := vr.Next()
+		if err == io.EOF {
+			return values
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, string(value))
+	}
+}
+
+func TestValueReaderOverlappingDelim(t *testing.T) {
+	cases := []struct {
+		input, delim string
+		want         []string
+	}{
+		{"xaaay", "aa", []string{"x", "ay"}},
+		{"xaaaay", "aa", []string{"x", "", "y"}},
+		{"aaa", "aa", []string{"", "a"}},
+		{"xababay", "aba", []string{"x", "bay"}},
+		{"xabababy", "abab", []string{"x", "aby"}},
+		{"abcabcab", "abcab", []string{"", "cab"}},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var rd io.Reader = strings.NewReader(c.input)
+			if oneByte {
+				rd = iotest.OneByteReader(rd)
+			}
+			got := readAllValues(t, NewValueReader(rd, []byte(c.delim), 0))
+			if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+				t.Errorf("split %q by %q (one byte %v): got %q, want %q", c.input, c.delim, oneByte, got, c.want)
+			}
+		}
+	}
+}
+
+// 切分结果和 strings.Split 一致, 包括分隔符跨越读取缓冲区边界的情况
+func TestValueReaderMatchesStringsSplit(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, delim := range []string{"aa", "aba", "abab", "aab", "abcab", "\r\n"} {
+		for i := 0; i < 300; i++ {
+			buf := make([]byte, rnd.Intn(300))
+			for j := range buf {
+				buf[j] = "abc\r\n"[rnd.Intn(5)]
+			}
+			input := string(buf)
+			want := strings.Split(input, delim)
+			got := readAllValues(t, NewValueReaderSize(strings.NewReader(input), []byte(delim), 0, MinReadBufferSize))
+			if len(got) != len(want) || strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Fatalf("split %q by %q: got %q, want %q", input, delim, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package splitter
+
+import (
+	"context"
+	"io"
+	"iter"
+	"sync/atomic"
+)
+
+// 以迭代器的方式运行分隔
+func (s *splitter) Values(rd io.Reader) iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		// 防止重复调用
+		if atomic.AddInt32(&s.started, 1) != 1 {
+			yield(nil, ErrSplitterIsStarted)
+			return
+		}
+
+		var err error
+		ctx, _, vr, end := s.begin(context.Background(), rd)
+		defer func() { end(err) }()
+
+		if s.onStart != nil {
+			if err = s.onStart(); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		for {
+			var value []byte
+			value, _, err = s.nextValue(ctx, vr)
+			if err != nil && err != io.EOF {
+				yield(nil, err)
+				return
+			}
+
+			if value != nil {
+				s.nextValueSn++
+				s.stats.ValueNum++
+				if !yield(value, nil) || s.reachMaxValueCount() {
+					return
+				}
+			}
+
+			if err == io.EOF {
+				err = nil
+				return
+			}
+		}
+	}
+}
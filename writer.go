@@ -0,0 +1,34 @@
+package splitter
+
+import (
+	"io"
+)
+
+// 创建一个将每个 chunk 写入 w 的分隔器, 每个 chunk 后会写入 sep. 会覆盖 conf.FlushChunkHandler 并忽略 conf.FlushChunkCtxHandler, 写入失败时 RunSplit 会返回这个错误.
+// handler 不会持有 chunk 数据, 所以会开启 conf.DisableChunkCopy 直接写入内部 chunk 缓冲区, 并发 flush 时仍会复制
+func NewWriterSplitter(conf Conf, w io.Writer, sep []byte) Splitter {
+	conf.FlushChunkHandler = WriterFlushChunkHandler(w, sep)
+	conf.FlushChunkCtxHandler = nil
+	conf.DisableChunkCopy = true
+	return NewSplitter(conf)
+}
+
+// 返回一个将 chunk 写入 w 的 FlushChunkHandler, 每个 chunk 后会写入 sep. 它不会持有 chunk 数据, 可以配合 DisableChunkCopy 使用.
+// chunk 被 spill 时会从 SpillReader 复制数据
+func WriterFlushChunkHandler(w io.Writer, sep []byte) FlushChunkHandler {
+	return func(args *FlushChunkArgs) error {
+		if args.SpillReader != nil {
+			if _, err := io.Copy(w, args.SpillReader); err != nil {
+				return err
+			}
+		} else if _, err := w.Write(args.ChunkData); err != nil {
+			return err
+		}
+		if len(sep) > 0 {
+			if _, err := w.Write(sep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}